@@ -0,0 +1,430 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spec-tacles/gateway/gateway"
+)
+
+// Errors
+var (
+	ErrUnknownLogLevel = errors.New("unknown log level")
+	ErrShardNotFound   = errors.New("shard not found")
+	ErrGroupNotFound   = errors.New("shard group not found")
+	ErrGuildNotFound   = errors.New("guild not found")
+)
+
+// Options represents New's options
+type Options struct {
+	// Token is required as a bearer token on every request if set. Servers without a token should
+	// authenticate clients with mutual TLS instead.
+	Token string
+
+	// Sink returns the status of the broker that events are published to
+	Sink func() interface{}
+
+	// Recording is the path of the traffic recording that events are backfilled from
+	Recording string
+
+	// Chaos enables injecting faults into shards. It should only be enabled to verify reconnect and
+	// resume handling, and alerting.
+	Chaos bool
+}
+
+// LogLevel represents the body of log level requests
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// Chaos represents the body of fault injection requests. Each field is optional.
+type Chaos struct {
+	DropFrames    int    `json:"drop_frames"`
+	CorruptFrames int    `json:"corrupt_frames"`
+	AckDelay      string `json:"ack_delay"`
+	CloseCode     int    `json:"close_code"`
+}
+
+// Backfill represents the body of backfill requests. Times are formatted as RFC 3339.
+type Backfill struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// BackfillResult represents the response to backfill requests
+type BackfillResult struct {
+	Published int `json:"published"`
+}
+
+// Events represents the body of event filter requests
+type Events struct {
+	Events []string `json:"events"`
+}
+
+type handler struct {
+	manager *gateway.Manager
+	opts    *Options
+	mux     *http.ServeMux
+}
+
+// New creates an HTTP handler exposing administrative actions for the manager:
+//
+//	GET       /health                  startup progress; 503 until every shard has been ready
+//	GET       /shards                  snapshots of every shard
+//	GET       /shards/{id}             snapshot of a shard
+//	POST      /shards/{id}/drain       close a shard resumably
+//	POST      /shards/{id}/reidentify  replace a shard's session
+//	POST      /shards/{id}/chaos       inject faults into a shard (if enabled)
+//	GET       /groups                  health of every shard group
+//	GET       /groups/{name}           health of a shard group
+//	POST      /groups/{name}/start     start a shard group's stopped shards
+//	POST      /groups/{name}/stop      close a shard group, invalidating its sessions
+//	POST      /groups/{name}/drain     close a shard group resumably
+//	GET       /guilds                  shard serving every guild
+//	GET       /guilds/{id}             shard serving a guild
+//	GET, PUT  /log-level               log level of the manager and its shards
+//	GET, PUT  /events                  events published to the broker
+//	GET       /sink                    status of the broker
+//	POST      /backfill                publish recorded events from a time range to the broker
+func New(m *gateway.Manager, opts *Options) http.Handler {
+	h := &handler{
+		manager: m,
+		opts:    opts,
+		mux:     http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("/health", h.health)
+	h.mux.HandleFunc("/shards", h.shards)
+	h.mux.HandleFunc("/shards/", h.shard)
+	h.mux.HandleFunc("/groups", h.groups)
+	h.mux.HandleFunc("/groups/", h.group)
+	h.mux.HandleFunc("/guilds", h.guilds)
+	h.mux.HandleFunc("/guilds/", h.guild)
+	h.mux.HandleFunc("/log-level", h.logLevel)
+	h.mux.HandleFunc("/events", h.events)
+	h.mux.HandleFunc("/sink", h.sink)
+	h.mux.HandleFunc("/backfill", h.backfill)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.opts.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) health(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	progress := h.manager.Progress()
+	if !progress.Done {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(progress)
+		return
+	}
+	respond(w, progress)
+}
+
+func (h *handler) shards(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	respond(w, h.manager.Snapshot())
+}
+
+func (h *handler) shard(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/shards/"), "/")
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "invalid shard ID", http.StatusBadRequest)
+		return
+	}
+
+	s := h.manager.Shard(id)
+	if s == nil {
+		http.Error(w, ErrShardNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		if allow(w, r, http.MethodGet) {
+			respond(w, s.Snapshot())
+		}
+		return
+	}
+
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+
+	switch parts[1] {
+	case "drain":
+		err = s.CloseResumable()
+	case "reidentify":
+		err = s.Reidentify()
+	case "chaos":
+		if !h.opts.Chaos {
+			http.NotFound(w, r)
+			return
+		}
+
+		body := new(Chaos)
+		if !decode(w, r, body) {
+			return
+		}
+
+		var ackDelay time.Duration
+		if body.AckDelay != "" {
+			if ackDelay, err = time.ParseDuration(body.AckDelay); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		err = injectChaos(s, body, ackDelay)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handler) groups(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	groups := h.manager.Groups()
+	health := make([]gateway.ShardGroupHealth, 0, len(groups))
+	for _, g := range groups {
+		health = append(health, g.Health())
+	}
+	respond(w, health)
+}
+
+func (h *handler) group(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	g := h.manager.Group(parts[0])
+	if g == nil {
+		http.Error(w, ErrGroupNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		if allow(w, r, http.MethodGet) {
+			respond(w, g.Health())
+		}
+		return
+	}
+
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+
+	switch parts[1] {
+	case "start":
+		if err := g.Start(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	case "stop":
+		g.Stop()
+	case "drain":
+		g.Drain()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handler) guilds(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	respond(w, h.manager.GuildRoutes())
+}
+
+func (h *handler) guild(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/guilds/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid guild ID", http.StatusBadRequest)
+		return
+	}
+
+	route, ok := h.manager.GuildRoute(id)
+	if !ok {
+		http.Error(w, ErrGuildNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	respond(w, route)
+}
+
+func (h *handler) logLevel(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		body := new(LogLevel)
+		if !decode(w, r, body) {
+			return
+		}
+
+		level, ok := gateway.LogLevels[body.Level]
+		if !ok {
+			http.Error(w, ErrUnknownLogLevel.Error(), http.StatusBadRequest)
+			return
+		}
+		h.manager.SetLogLevel(level)
+	}
+
+	current := h.manager.LogLevel()
+	for name, level := range gateway.LogLevels {
+		if level == current {
+			respond(w, LogLevel{name})
+			return
+		}
+	}
+	respond(w, LogLevel{strconv.Itoa(current)})
+}
+
+func (h *handler) events(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		body := new(Events)
+		if !decode(w, r, body) {
+			return
+		}
+		h.manager.SetEvents(body.Events)
+	}
+
+	respond(w, Events{h.manager.Events()})
+}
+
+func (h *handler) sink(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	if h.opts.Sink == nil {
+		http.NotFound(w, r)
+		return
+	}
+	respond(w, h.opts.Sink())
+}
+
+func (h *handler) backfill(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+
+	if h.opts.Recording == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body := new(Backfill)
+	if !decode(w, r, body) {
+		return
+	}
+
+	f, err := os.Open(h.opts.Recording)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	published, err := h.manager.Backfill(r.Context(), f, body.From, body.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respond(w, BackfillResult{published})
+}
+
+// injectChaos applies the requested faults to the shard
+func injectChaos(s *gateway.Shard, c *Chaos, ackDelay time.Duration) error {
+	if c.AckDelay != "" {
+		s.DelayAcks(ackDelay)
+	}
+
+	if c.DropFrames > 0 {
+		s.DropFrames(c.DropFrames)
+	}
+	if c.CorruptFrames > 0 {
+		s.CorruptFrames(c.CorruptFrames)
+	}
+
+	if c.CloseCode != 0 {
+		return s.ForceClose(c.CloseCode)
+	}
+	return nil
+}
+
+// allow responds with 405 Method Not Allowed and returns false if the request method isn't allowed
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// decode decodes the JSON request body, responding with 400 Bad Request if it's invalid
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -2,16 +2,31 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/mediocregopher/radix/v4"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/spec-tacles/gateway/admin"
+	"github.com/spec-tacles/gateway/compression"
+	"github.com/spec-tacles/gateway/compression/bench"
 	"github.com/spec-tacles/gateway/config"
+	"github.com/spec-tacles/gateway/conformance"
 	"github.com/spec-tacles/gateway/gateway"
+	"github.com/spec-tacles/gateway/stats"
 	"github.com/spec-tacles/go/broker"
 	"github.com/spec-tacles/go/broker/amqp"
 	"github.com/spec-tacles/go/broker/redis"
@@ -20,16 +35,13 @@ import (
 )
 
 var (
-	logger    = gateway.ChildLogger(gateway.DefaultLogger, "[CMD]")
-	logLevels = map[string]int{
-		"suppress": gateway.LogLevelSuppress,
-		"info":     gateway.LogLevelInfo,
-		"warn":     gateway.LogLevelWarn,
-		"debug":    gateway.LogLevelDebug,
-		"error":    gateway.LogLevelError,
-	}
+	logger         = gateway.ChildLogger(gateway.DefaultLogger, "[CMD]")
 	logLevel       = flag.String("loglevel", "info", "log level for the client")
 	configLocation = flag.String("config", "gateway.toml", "location of the gateway config file")
+	benchCapture   = flag.String("bench-compression", "", "benchmark compression codecs using the given traffic capture and exit")
+	trainCapture   = flag.String("train-dictionary", "", "train a zstd dictionary from the given traffic capture, write it to -dictionary, and exit")
+	dictionary     = flag.String("dictionary", "", "zstd dictionary file to benchmark or write (default: built-in sample dictionary, not trained from real traffic)")
+	conform        = flag.Bool("conformance", false, "run the gateway protocol conformance scenarios against a mock gateway and exit")
 )
 
 var redisActor redis.RedisActor
@@ -39,59 +51,100 @@ func getRedis(ctx context.Context, conf *config.Config) redis.RedisActor {
 		return redisActor
 	}
 
+	redisActor = dialRedis(ctx, conf.Redis.URLs, conf.Redis.PoolSize)
+	return redisActor
+}
+
+func dialRedis(ctx context.Context, urls []string, poolSize int) redis.RedisActor {
 	var (
 		newClient redis.RedisActor
 		err       error
 		poolConf  = radix.PoolConfig{
-			Size: conf.Redis.PoolSize,
+			Size: poolSize,
 		}
 	)
 
-	if len(conf.Redis.URLs) > 1 {
+	if len(urls) > 1 {
 		newClient, err = radix.ClusterConfig{
 			PoolConfig: poolConf,
-		}.New(ctx, conf.Redis.URLs)
+		}.New(ctx, urls)
 	} else {
-		newClient, err = poolConf.New(ctx, "tcp", conf.Redis.URLs[0])
+		newClient, err = poolConf.New(ctx, "tcp", urls[0])
 	}
 
 	if err != nil {
 		logger.Fatalf("Unable to connect to redis: %s", err)
 	}
-
-	redisActor = newClient
 	return newClient
 }
 
+// newShardStore creates the shard store described by the config, using the given function to
+// connect to Redis if necessary
+func newShardStore(conf *config.Config, connect func() redis.RedisActor) (shardStore gateway.ShardStore) {
+	switch conf.ShardStore.Type {
+	case "redis":
+		shardStore = &gateway.RedisShardStore{
+			Redis:  connect(),
+			Prefix: conf.ShardStore.Prefix,
+		}
+	}
+
+	if conf.ShardStore.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(conf.ShardStore.EncryptionKey)
+		if err != nil {
+			logger.Fatalf("invalid shard store encryption key: %s", err)
+		}
+
+		if shardStore == nil {
+			shardStore = gateway.NewLocalShardStore()
+		}
+
+		shardStore, err = gateway.NewEncryptedShardStore(shardStore, key)
+		if err != nil {
+			logger.Fatalf("unable to initialize shard store encryption: %s", err)
+		}
+	}
+	return
+}
+
 // Run runs the CLI app
 func Run() {
-	logger.Println("starting gateway")
 	flag.Parse()
+	if *benchCapture != "" {
+		benchCompression(*benchCapture)
+		return
+	}
 
-	conf, err := config.Read(*configLocation)
-	if err != nil {
-		logger.Fatalf("unable to load config: %s\n", err)
+	if *trainCapture != "" {
+		trainDictionary(*trainCapture)
+		return
 	}
 
-	if conf.Prometheus.Address != "" {
-		var mainHandler http.Handler
-		if conf.Prometheus.Endpoint == "" {
-			mainHandler = promhttp.Handler()
-		} else {
-			http.Handle(conf.Prometheus.Endpoint, promhttp.Handler())
+	if *conform {
+		results := conformance.Run(context.Background(), conformance.Options{})
+		if !conformance.Report(os.Stdout, results) {
+			os.Exit(1)
 		}
+		return
+	}
 
-		logger.Printf("exposing Prometheus stats at %v%v", conf.Prometheus.Address, conf.Prometheus.Endpoint)
-		go func() {
-			logger.Fatal(http.ListenAndServe(conf.Prometheus.Address, mainHandler))
-		}()
+	if flag.Arg(0) == "migrate-store" {
+		migrateStore(flag.Args()[1:])
+		return
+	}
+
+	logger.Println("starting gateway")
+
+	conf, err := config.Read(*configLocation)
+	if err != nil {
+		logger.Fatalf("unable to load config: %s\n", err)
 	}
 
 	var (
 		manager    *gateway.Manager
 		b          broker.Broker
 		shardStore gateway.ShardStore
-		logLevel   = logLevels[*logLevel]
+		logLevel   = gateway.LogLevels[*logLevel]
 		ctx        = context.Background()
 	)
 
@@ -118,12 +171,80 @@ func Run() {
 		b = &broker.RWBroker{R: os.Stdin, W: os.Stdout}
 	}
 
-	switch conf.ShardStore.Type {
-	case "redis":
-		redis := getRedis(ctx, conf)
-		shardStore = &gateway.RedisShardStore{
-			Redis:  redis,
-			Prefix: conf.ShardStore.Prefix,
+	var batcher *gateway.BatchBroker
+	if batch := conf.Broker.Batch; batch.MaxEvents > 0 || batch.MaxBytes > 0 || batch.MaxLatency.Duration > 0 {
+		batcher = gateway.NewBatchBroker(ctx, b, gateway.BatchOptions{
+			MaxEvents:  batch.MaxEvents,
+			MaxBytes:   batch.MaxBytes,
+			MaxLatency: batch.MaxLatency.Duration,
+			OnError: func(err error) {
+				logger.Printf("failed to publish batch to broker: %s", err)
+			},
+		})
+		b = batcher
+	}
+
+	shardStore = newShardStore(conf, func() redis.RedisActor {
+		return getRedis(ctx, conf)
+	})
+	if shardStore == nil {
+		shardStore = gateway.NewLocalShardStore()
+	}
+	shardStore = gateway.NewInstrumentedShardStore(shardStore)
+
+	var writeBehind *gateway.WriteBehindShardStore
+	if interval := conf.ShardStore.WriteBehind.Duration; interval > 0 {
+		writeBehind = gateway.NewWriteBehindShardStore(ctx, shardStore, interval, func(err error) {
+			logger.Printf("failed to write sequences to shard store: %s", err)
+		})
+		shardStore = writeBehind
+	}
+
+	var onUnknownEvent func(*types.ReceivePacket)
+	if conf.UnknownEventsFile != "" {
+		f, err := os.OpenFile(conf.UnknownEventsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logger.Fatalf("unable to open unknown events file: %s", err)
+		}
+
+		var mux sync.Mutex
+		enc := json.NewEncoder(f)
+		onUnknownEvent = func(p *types.ReceivePacket) {
+			mux.Lock()
+			defer mux.Unlock()
+
+			if err := enc.Encode(p); err != nil {
+				logger.Printf("unable to write unknown event %s: %s", p.Event, err)
+			}
+		}
+	}
+
+	var bus *gateway.Bus
+	if conf.RecordFile != "" {
+		f, err := os.OpenFile(conf.RecordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logger.Fatalf("unable to open record file: %s", err)
+		}
+
+		bus = gateway.NewBus()
+		sub := bus.Subscribe(gateway.SubscriptionOptions{Name: "recorder", QueueSize: 4096})
+		go gateway.NewRecorder(f).Run(sub, func(err error) {
+			logger.Printf("unable to record event: %s", err)
+		})
+	}
+
+	sampleRates := make(map[types.GatewayEvent]float64, len(conf.Sampling))
+	for event, rate := range conf.Sampling {
+		sampleRates[types.GatewayEvent(event)] = rate
+	}
+
+	var metrics *stats.ServerOptions
+	if conf.Prometheus.Address != "" {
+		metrics = &stats.ServerOptions{
+			Address: conf.Prometheus.Address,
+			Path:    conf.Prometheus.Endpoint,
+			TLSCert: conf.Prometheus.TLSCert,
+			TLSKey:  conf.Prometheus.TLSKey,
 		}
 	}
 
@@ -131,6 +252,23 @@ func Run() {
 	r.URLHost = conf.API.Host
 	r.URLScheme = conf.API.Scheme
 
+	groups := make([]gateway.ShardGroupOptions, len(conf.Groups))
+	for i, g := range conf.Groups {
+		groups[i] = gateway.ShardGroupOptions{
+			Name:   g.Name,
+			Shards: g.Shards,
+			Labels: g.Labels,
+		}
+	}
+
+	// a single limiter caps the combined bandwidth of every shard
+	var egress *gateway.ByteLimiter
+	if conf.Egress.BytesPerSecond > 0 {
+		if egress, err = gateway.NewByteLimiter(conf.Egress.BytesPerSecond, conf.Egress.Burst); err != nil {
+			logger.Fatalf("invalid egress limit: %s", err)
+		}
+	}
+
 	manager = gateway.NewManager(&gateway.ManagerOptions{
 		ShardOptions: &gateway.ShardOptions{
 			Store: shardStore,
@@ -139,13 +277,42 @@ func Run() {
 				Intents:  int(conf.RawIntents),
 				Presence: &conf.Presence,
 			},
-			Version: conf.GatewayVersion,
+			Version:          conf.GatewayVersion,
+			OnUnknownEvent:   onUnknownEvent,
+			Bus:              bus,
+			SampleRates:      sampleRates,
+			DowngradeIntents: config.ParseIntents(conf.DowngradeIntents),
+			ReadOnly:         conf.ReadOnly,
+			EgressLimiter:    egress,
+
+			ResyncPresenceOnResume: conf.ResyncPresenceOnResume,
+			MaxReconnectAttempts:   conf.MaxReconnectAttempts,
+
+			SeqPersistEvents:   conf.ShardStore.SeqPersist.Events,
+			SeqPersistInterval: conf.ShardStore.SeqPersist.Interval.Duration,
 		},
 		REST:       r,
 		LogLevel:   logLevel,
 		ShardCount: conf.Shards.Count,
+		Labels:     conf.Labels,
+		Envelope:   conf.Broker.Envelope,
+		ShardTags: func(int) map[string]string {
+			return conf.Shards.Tags
+		},
+		Metrics:      metrics,
+		Groups:       groups,
+		CanaryShards: conf.Canary.Shards,
+		CanaryOptions: func(opts *gateway.ShardOptions) {
+			if conf.Canary.GatewayVersion != 0 {
+				opts.Version = conf.Canary.GatewayVersion
+			}
+		},
 	})
 
+	if conf.Admin.Address != "" {
+		serveAdmin(conf, manager, b, batcher)
+	}
+
 	evts := make(map[string]struct{})
 	for _, e := range conf.Events {
 		evts[e] = struct{}{}
@@ -153,7 +320,210 @@ func Run() {
 	manager.ConnectBroker(ctx, b, evts)
 
 	logger.Printf("using config:\n%+v\n", conf)
-	if err := manager.Start(ctx); err != nil {
-		logger.Fatalf("failed to connect to discord: %v", err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.Start(ctx)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Fatalf("failed to connect to discord: %v", err)
+		}
+	case sig := <-signals:
+		complete := shutdown(manager, sig, conf.ShutdownTimeout.Duration, done)
+		if batcher != nil {
+			if err := batcher.Flush(ctx); err != nil {
+				logger.Printf("failed to flush batches to broker: %s", err)
+			}
+		}
+		if writeBehind != nil {
+			if err := writeBehind.Flush(ctx); err != nil {
+				logger.Printf("failed to write sequences to shard store: %s", err)
+			}
+		}
+
+		if !complete {
+			os.Exit(1)
+		}
+	}
+}
+
+// serveAdmin serves the admin API in the background. Clients must authenticate with the token,
+// a certificate signed by the client CA, or both.
+func serveAdmin(conf *config.Config, manager *gateway.Manager, b broker.Broker, batcher *gateway.BatchBroker) {
+	if conf.Admin.Token == "" && conf.Admin.ClientCA == "" {
+		logger.Fatalf("admin API requires a token or client CA")
+	}
+
+	server := &http.Server{
+		Addr: conf.Admin.Address,
+		Handler: admin.New(manager, &admin.Options{
+			Token:     conf.Admin.Token,
+			Chaos:     conf.Admin.Chaos,
+			Recording: conf.RecordFile,
+			Sink: func() interface{} {
+				status := map[string]interface{}{
+					"type":     conf.Broker.Type,
+					"broker":   fmt.Sprintf("%T", b),
+					"envelope": conf.Broker.Envelope,
+				}
+				if batcher != nil {
+					events, bytes := batcher.Pending()
+					status["pending_events"] = events
+					status["pending_bytes"] = bytes
+				}
+				return status
+			},
+		}),
+	}
+
+	if conf.Admin.ClientCA != "" {
+		if conf.Admin.TLSCert == "" || conf.Admin.TLSKey == "" {
+			logger.Fatalf("admin API client CA requires a TLS certificate and key")
+		}
+
+		pem, err := os.ReadFile(conf.Admin.ClientCA)
+		if err != nil {
+			logger.Fatalf("unable to read admin API client CA: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			logger.Fatalf("admin API client CA contains no certificates")
+		}
+
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+
+	logger.Printf("exposing admin API at %s", conf.Admin.Address)
+	go func() {
+		if conf.Admin.TLSCert != "" {
+			logger.Fatal(server.ListenAndServeTLS(conf.Admin.TLSCert, conf.Admin.TLSKey))
+		}
+		logger.Fatal(server.ListenAndServe())
+	}()
+}
+
+// trainDictionary trains a zstd dictionary from the capture and writes it to the dictionary file
+func trainDictionary(capture string) {
+	if *dictionary == "" {
+		logger.Fatalf("-dictionary is required to write the trained dictionary")
+	}
+
+	dict, err := compression.TrainDictionary(readCapture(capture), compression.DefaultDictionarySize)
+	if err != nil {
+		logger.Fatalf("unable to train dictionary: %s", err)
+	}
+
+	if err = os.WriteFile(*dictionary, dict, 0644); err != nil {
+		logger.Fatalf("unable to write dictionary: %s", err)
+	}
+	logger.Printf("wrote %d byte dictionary to %s", len(dict), *dictionary)
+}
+
+// shutdown closes all shards resumably, waiting at most the given grace period for them to close.
+// Returns whether every shard closed in time.
+func shutdown(manager *gateway.Manager, sig os.Signal, grace time.Duration, done <-chan error) bool {
+	logger.Printf("received %s: closing shards (grace period %s)", sig, grace)
+	start := time.Now()
+	manager.Close()
+
+	select {
+	case <-done:
+		logger.Printf("shutdown complete: closed all shards resumably in %s", time.Since(start))
+		return true
+	case <-time.After(grace):
+		logger.Printf("shutdown incomplete: grace period exceeded, exiting with shards still open")
+		return false
+	}
+}
+
+// readCapture reads the messages in a traffic capture
+func readCapture(capture string) [][]byte {
+	f, err := os.Open(capture)
+	if err != nil {
+		logger.Fatalf("unable to open capture: %s", err)
+	}
+	defer f.Close()
+
+	messages, err := bench.ReadCapture(f)
+	if err != nil {
+		logger.Fatalf("unable to read capture: %s", err)
+	}
+	return messages
+}
+
+// benchCompression benchmarks every compression codec against the capture and prints the results
+func benchCompression(capture string) {
+	messages := readCapture(capture)
+
+	dict := compression.DefaultDictionary
+	if *dictionary != "" {
+		var err error
+		if dict, err = os.ReadFile(*dictionary); err != nil {
+			logger.Fatalf("unable to read dictionary: %s", err)
+		}
+	}
+
+	results, err := bench.Run(messages, dict)
+	if err != nil {
+		logger.Fatalf("benchmark failed: %s", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODEC\tMESSAGES\tRAW BYTES\tWIRE BYTES\tRATIO\tWALL TIME\tCPU TIME\tALLOCS\tALLOC BYTES")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f\t%s\t%s\t%d\t%d\n", r.Codec, r.Messages, r.RawBytes, r.WireBytes, r.Ratio(), r.Duration, r.CPUTime, r.Allocs, r.AllocBytes)
+	}
+	w.Flush()
+}
+
+// migrateStore copies shard sessions from the configured shard store to the one described by args
+func migrateStore(args []string) {
+	conf, err := config.Read(*configLocation)
+	if err != nil {
+		logger.Fatalf("unable to load config: %s\n", err)
+	}
+
+	to := *conf
+	flags := flag.NewFlagSet("migrate-store", flag.ExitOnError)
+	flags.StringVar(&to.ShardStore.Type, "to-type", conf.ShardStore.Type, "type of the destination shard store")
+	flags.StringVar(&to.ShardStore.Prefix, "to-prefix", conf.ShardStore.Prefix, "key prefix of the destination shard store")
+	flags.StringVar(&to.ShardStore.EncryptionKey, "to-encryption-key", conf.ShardStore.EncryptionKey, "encryption key of the destination shard store")
+	toRedis := flags.String("to-redis", "", "comma-separated Redis URLs of the destination shard store (defaults to the configured Redis)")
+	shardCount := flags.Int("shards", conf.Shards.Count, "number of shards to migrate")
+	flags.Parse(args)
+
+	if conf.ShardStore.Type != "redis" || to.ShardStore.Type != "redis" {
+		logger.Fatalf("migrating requires persistent source and destination shard stores")
+	}
+
+	if *shardCount <= 0 {
+		logger.Fatalf("shard count is required to migrate")
+	}
+
+	ctx := context.Background()
+	from := newShardStore(conf, func() redis.RedisActor {
+		return getRedis(ctx, conf)
+	})
+	dest := newShardStore(&to, func() redis.RedisActor {
+		if *toRedis == "" {
+			return getRedis(ctx, conf)
+		}
+		return dialRedis(ctx, strings.Split(*toRedis, ","), conf.Redis.PoolSize)
+	})
+
+	migrated, err := gateway.StoreMigrate(ctx, from, dest, *shardCount)
+	if err != nil {
+		logger.Fatalf("migration failed after %d shard(s): %s", migrated, err)
 	}
+	logger.Printf("migrated %d of %d shard(s)", migrated, *shardCount)
 }
@@ -0,0 +1,259 @@
+// Package bench replays recorded gateway traffic through each supported transport compression codec
+// to compare their cost empirically.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/spec-tacles/gateway/compression"
+	"github.com/valyala/gozstd"
+)
+
+// ErrMismatch occurs when a codec's output doesn't match the original message
+var ErrMismatch = errors.New("decompressed message does not match original")
+
+// Result represents the cost of decompressing a capture with a single codec
+type Result struct {
+	Codec    string
+	Messages int
+	// RawBytes is the total size of the uncompressed messages
+	RawBytes int64
+	// WireBytes is the total size of the messages as received over the connection
+	WireBytes int64
+	// Duration is the wall time spent decompressing all messages
+	Duration time.Duration
+	// CPUTime is the user and system CPU time used by the process while decompressing all messages,
+	// including any concurrent garbage collection. It's zero on platforms where it isn't measured.
+	CPUTime time.Duration
+	// Allocs and AllocBytes are the number and total size of heap allocations made while
+	// decompressing all messages
+	Allocs     uint64
+	AllocBytes uint64
+}
+
+// Ratio returns the compression ratio (raw size / wire size)
+func (r Result) Ratio() float64 {
+	if r.WireBytes == 0 {
+		return 0
+	}
+	return float64(r.RawBytes) / float64(r.WireBytes)
+}
+
+// codec compresses messages as Discord would and returns a function that decompresses them as a
+// shard would
+type codec struct {
+	name     string
+	compress func(messages [][]byte) ([][]byte, error)
+	// decompressor returns a fresh decompression function for a single connection
+	decompressor func() func(d []byte, size int) ([]byte, error)
+}
+
+var codecs = []codec{
+	{"none", compressNone, decompressNone},
+	{"zlib-stream", compressZlib, decompressZlib},
+	{"zstd-stream", compressZstd, decompressZstd},
+}
+
+// ReadCapture reads a traffic capture consisting of one JSON payload per line, as written by the
+// daemon's unknown events file
+func ReadCapture(r io.Reader) (messages [][]byte, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		messages = append(messages, append([]byte(nil), line...))
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// Run replays the messages through every codec. If a dictionary is given, zstd is also run using
+// it.
+func Run(messages [][]byte, dict []byte) (results []Result, err error) {
+	all := codecs
+	if dict != nil {
+		all = append(all[:len(all):len(all)], codec{"zstd-dict", compressZstdDict(dict), decompressZstdDict(dict)})
+	}
+
+	for _, c := range all {
+		var r Result
+		if r, err = run(c, messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.name, err)
+		}
+		results = append(results, r)
+	}
+	return
+}
+
+func run(c codec, messages [][]byte) (r Result, err error) {
+	wire, err := c.compress(messages)
+	if err != nil {
+		return
+	}
+
+	r.Codec = c.name
+	r.Messages = len(messages)
+	for i := range messages {
+		r.RawBytes += int64(len(messages[i]))
+		r.WireBytes += int64(len(wire[i]))
+	}
+
+	decompress := c.decompressor()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start, startCPU := time.Now(), cpuTime()
+
+	for i, d := range wire {
+		var out []byte
+		if out, err = decompress(d, len(messages[i])); err != nil {
+			return
+		}
+
+		// decompressors may reuse their output buffer, so verify each message immediately
+		if !bytes.Equal(out, messages[i]) {
+			err = ErrMismatch
+			return
+		}
+	}
+
+	r.Duration, r.CPUTime = time.Since(start), cpuTime()-startCPU
+	runtime.ReadMemStats(&after)
+	r.Allocs = after.Mallocs - before.Mallocs
+	r.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	return
+}
+
+func compressNone(messages [][]byte) ([][]byte, error) {
+	return messages, nil
+}
+
+func decompressNone() func([]byte, int) ([]byte, error) {
+	return func(d []byte, _ int) ([]byte, error) {
+		return d, nil
+	}
+}
+
+// compressZlib compresses the messages as a single zlib stream, flushed after each message. At
+// lower levels, Go's compressor emits uncompressed blocks for small flushes, unlike Discord's.
+func compressZlib(messages [][]byte) (wire [][]byte, err error) {
+	buf := new(bytes.Buffer)
+	w, err := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if err != nil {
+		return
+	}
+
+	for _, m := range messages {
+		if _, err = w.Write(m); err != nil {
+			return
+		}
+		if err = w.Flush(); err != nil {
+			return
+		}
+
+		wire = append(wire, append([]byte(nil), buf.Bytes()...))
+		buf.Reset()
+	}
+	return
+}
+
+func decompressZlib() func([]byte, int) ([]byte, error) {
+	var (
+		buf = new(bytes.Buffer)
+		zr  io.ReadCloser
+	)
+
+	return func(d []byte, size int) (out []byte, err error) {
+		buf.Write(d)
+		if zr == nil {
+			if zr, err = zlib.NewReader(buf); err != nil {
+				return
+			}
+		}
+
+		out = make([]byte, size)
+		_, err = io.ReadFull(zr, out)
+		return
+	}
+}
+
+// compressZstd compresses the messages as a single zstd stream, flushed after each message
+func compressZstd(messages [][]byte) (wire [][]byte, err error) {
+	buf := new(bytes.Buffer)
+	w := gozstd.NewWriter(buf)
+	defer w.Release()
+
+	for _, m := range messages {
+		if _, err = w.Write(m); err != nil {
+			return
+		}
+		if err = w.Flush(); err != nil {
+			return
+		}
+
+		wire = append(wire, append([]byte(nil), buf.Bytes()...))
+		buf.Reset()
+	}
+	return
+}
+
+func decompressZstd() func([]byte, int) ([]byte, error) {
+	z := compression.NewZstd()
+	return func(d []byte, _ int) ([]byte, error) {
+		return z.Decompress(d)
+	}
+}
+
+// compressZstdDict compresses the messages as a single zstd stream using the dictionary
+func compressZstdDict(dict []byte) func([][]byte) ([][]byte, error) {
+	return func(messages [][]byte) (wire [][]byte, err error) {
+		cd, err := gozstd.NewCDict(dict)
+		if err != nil {
+			return
+		}
+		defer cd.Release()
+
+		buf := new(bytes.Buffer)
+		w := gozstd.NewWriterDict(buf, cd)
+		defer w.Release()
+
+		for _, m := range messages {
+			if _, err = w.Write(m); err != nil {
+				return
+			}
+			if err = w.Flush(); err != nil {
+				return
+			}
+
+			wire = append(wire, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		return
+	}
+}
+
+func decompressZstdDict(dict []byte) func() func([]byte, int) ([]byte, error) {
+	return func() func([]byte, int) ([]byte, error) {
+		z, err := compression.NewZstdDict(dict)
+		return func(d []byte, _ int) ([]byte, error) {
+			if err != nil {
+				return nil, err
+			}
+			return z.Decompress(d)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package bench
+
+import "time"
+
+// cpuTime isn't measured on this platform, so CPU times are reported as zero
+func cpuTime() time.Duration {
+	return 0
+}
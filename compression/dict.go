@@ -0,0 +1,37 @@
+package compression
+
+import (
+	_ "embed" // for DefaultDictionary
+	"errors"
+
+	"github.com/valyala/gozstd"
+)
+
+// DefaultDictionarySize is the default size in bytes of trained dictionaries
+const DefaultDictionarySize = 16 * 1024
+
+// ErrTooFewSamples occurs when there isn't enough sample data to train a dictionary
+var ErrTooFewSamples = errors.New("too few samples to train a dictionary")
+
+// DefaultDictionary is a raw content zstd dictionary of hand-written sample gateway payloads. It
+// wasn't trained from real traffic and isn't representative of any particular bot, so benchmarks
+// against it are only indicative; use TrainDictionary on a capture of your own traffic instead.
+// Discord doesn't compress with a dictionary, so it only applies where both ends of a stream are
+// under the operator's control, such as between a gateway proxy and its shards.
+//
+//go:embed gateway.dict
+var DefaultDictionary []byte
+
+// TrainDictionary trains a zstd dictionary of up to the given size from sample payloads, such as
+// those in a traffic capture
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	if len(samples) < 8 {
+		return nil, ErrTooFewSamples
+	}
+
+	dict := gozstd.BuildDict(samples, size)
+	if len(dict) == 0 {
+		return nil, ErrTooFewSamples
+	}
+	return dict, nil
+}
@@ -21,6 +21,32 @@ func NewZstd() *Zstd {
 
 	dr, dw := io.Pipe()
 	zr := gozstd.NewReader(dr)
+	return newZstd(zw, cr, zr, dw)
+}
+
+// NewZstdDict creates a zstd context that compresses and decompresses using the given dictionary.
+// Both ends of a stream must use the same dictionary.
+func NewZstdDict(dict []byte) (*Zstd, error) {
+	cd, err := gozstd.NewCDict(dict)
+	if err != nil {
+		return nil, err
+	}
+
+	dd, err := gozstd.NewDDict(dict)
+	if err != nil {
+		cd.Release()
+		return nil, err
+	}
+
+	cr := &ChanWriter{make(chan []byte)}
+	zw := gozstd.NewWriterDict(cr, cd)
+
+	dr, dw := io.Pipe()
+	zr := gozstd.NewReaderDict(dr, dd)
+	return newZstd(zw, cr, zr, dw), nil
+}
+
+func newZstd(zw *gozstd.Writer, cr *ChanWriter, zr *gozstd.Reader, dw io.Writer) *Zstd {
 	dChanWriter := &ChanWriter{make(chan []byte)}
 	go zr.WriteTo(dChanWriter)
 	return &Zstd{zw, cr, dw, dChanWriter}
@@ -24,29 +24,76 @@ func (d *duration) UnmarshalText(text []byte) (err error) {
 
 // Config represents configuration structure for the gateway
 type Config struct {
-	Token          string
-	Events         []string
-	Intents        []string
-	RawIntents     uint
-	GatewayVersion uint `toml:"gateway_version"`
-	Shards         struct {
+	Token             string
+	Events            []string
+	Intents           []string
+	RawIntents        uint
+	DowngradeIntents  []string `toml:"downgrade_intents"`
+	ReadOnly          bool     `toml:"read_only"`
+	GatewayVersion    uint     `toml:"gateway_version"`
+	UnknownEventsFile string   `toml:"unknown_events_file"`
+	RecordFile        string   `toml:"record_file"`
+	ShutdownTimeout   duration `toml:"shutdown_timeout"`
+	Shards            struct {
 		Count int
 		IDs   []int
+		Tags  map[string]string
+	}
+	Canary struct {
+		Shards         []int
+		GatewayVersion uint `toml:"gateway_version"`
 	}
 	Broker struct {
 		Type           string
+		Envelope       bool
 		Group          string
 		MessageTimeout duration `toml:"message_timeout"`
+		Batch          struct {
+			MaxEvents  int      `toml:"max_events"`
+			MaxBytes   int      `toml:"max_bytes"`
+			MaxLatency duration `toml:"max_latency"`
+		}
 	}
 	Prometheus struct {
 		Address  string
 		Endpoint string
+		TLSCert  string `toml:"tls_cert"`
+		TLSKey   string `toml:"tls_key"`
+	}
+	Admin struct {
+		Address  string
+		Token    string
+		TLSCert  string `toml:"tls_cert"`
+		TLSKey   string `toml:"tls_key"`
+		ClientCA string `toml:"client_ca"`
+		Chaos    bool
 	}
 	ShardStore struct {
-		Type   string
-		Prefix string
+		Type          string
+		Prefix        string
+		EncryptionKey string   `toml:"encryption_key"`
+		WriteBehind   duration `toml:"write_behind"`
+		SeqPersist    struct {
+			Events   int
+			Interval duration
+		} `toml:"seq_persist"`
 	} `toml:"shard_store"`
+	Egress struct {
+		BytesPerSecond int `toml:"bytes_per_second"`
+		Burst          int
+	}
 	Presence types.StatusUpdate
+	Labels   map[string]string
+	Sampling map[string]float64
+	Groups   []ShardGroup
+
+	// ResyncPresenceOnResume re-sends the last presence after resuming, as well as after
+	// identifying
+	ResyncPresenceOnResume bool `toml:"resync_presence_on_resume"`
+
+	// MaxReconnectAttempts makes shards give up after this many consecutive failed reconnects,
+	// rather than retrying forever
+	MaxReconnectAttempts int `toml:"max_reconnect_attempts"`
 
 	API struct {
 		Scheme  string
@@ -63,6 +110,13 @@ type Config struct {
 	}
 }
 
+// ShardGroup represents a group of shards managed as a unit
+type ShardGroup struct {
+	Name   string            `json:"name"`
+	Shards []int             `json:"shards"`
+	Labels map[string]string `json:"labels"`
+}
+
 // Read reads the config from file
 func Read(file string) (conf *Config, err error) {
 	conf = &Config{}
@@ -87,48 +141,11 @@ func (c *Config) Init() error {
 	}
 
 	if c.RawIntents == 0 {
-		for _, intent := range c.Intents {
-			switch intent {
-			case "GUILDS":
-				c.RawIntents |= types.IntentGuilds
-			case "GUILD_MEMBERS":
-				c.RawIntents |= types.IntentGuildMembers
-			case "GUILD_BANS":
-				c.RawIntents |= types.IntentGuildBans
-			case "GUILD_EMOJIS":
-				c.RawIntents |= types.IntentGuildEmojis
-			case "GUILD_INTEGRATIONS":
-				c.RawIntents |= types.IntentGuildIntegrations
-			case "GUILD_WEBHOOKS":
-				c.RawIntents |= types.IntentGuildWebhooks
-			case "GUILD_INVITES":
-				c.RawIntents |= types.IntentGuildInvites
-			case "GUILD_VOICE_STATES":
-				c.RawIntents |= types.IntentGuildVoiceStates
-			case "GUILD_PRESENCES":
-				c.RawIntents |= types.IntentGuildPresences
-			case "GUILD_MESSAGES":
-				c.RawIntents |= types.IntentGuildMessages
-			case "GUILD_MESSAGE_REACTIONS":
-				c.RawIntents |= types.IntentGuildMessageReactions
-			case "GUILD_MESSAGE_TYPING":
-				c.RawIntents |= types.IntentGuildMessageTyping
-			case "DIRECT_MESSAGES":
-				c.RawIntents |= types.IntentDirectMessages
-			case "DIRECT_MESSAGE_REACTIONS":
-				c.RawIntents |= types.IntentDirectMessageReactions
-			case "DIRECT_MESSAGE_TYPING":
-				c.RawIntents |= types.IntentDirectMessageTyping
-			case "MESSAGE_CONTENT":
-				c.RawIntents |= types.IntentMessageContent
-			case "GUILD_SCHEDULED_EVENTS":
-				c.RawIntents |= types.IntentGuildScheduledEvents
-			case "AUTO_MODERATION_CONFIGURATION":
-				c.RawIntents |= types.IntentAutoModerationConfiguration
-			case "AUTO_MODERATION_EXECUTION":
-				c.RawIntents |= types.IntentAutoModerationExecution
-			}
-		}
+		c.RawIntents = ParseIntents(c.Intents)
+	}
+
+	if c.ShutdownTimeout.Duration == time.Duration(0) {
+		c.ShutdownTimeout = duration{10 * time.Second}
 	}
 
 	if c.Redis.PoolSize == 0 {
@@ -150,6 +167,53 @@ func (c *Config) Init() error {
 	return nil
 }
 
+// ParseIntents combines the named gateway intents into a bitfield
+func ParseIntents(names []string) (intents uint) {
+	for _, intent := range names {
+		switch intent {
+		case "GUILDS":
+			intents |= types.IntentGuilds
+		case "GUILD_MEMBERS":
+			intents |= types.IntentGuildMembers
+		case "GUILD_BANS":
+			intents |= types.IntentGuildBans
+		case "GUILD_EMOJIS":
+			intents |= types.IntentGuildEmojis
+		case "GUILD_INTEGRATIONS":
+			intents |= types.IntentGuildIntegrations
+		case "GUILD_WEBHOOKS":
+			intents |= types.IntentGuildWebhooks
+		case "GUILD_INVITES":
+			intents |= types.IntentGuildInvites
+		case "GUILD_VOICE_STATES":
+			intents |= types.IntentGuildVoiceStates
+		case "GUILD_PRESENCES":
+			intents |= types.IntentGuildPresences
+		case "GUILD_MESSAGES":
+			intents |= types.IntentGuildMessages
+		case "GUILD_MESSAGE_REACTIONS":
+			intents |= types.IntentGuildMessageReactions
+		case "GUILD_MESSAGE_TYPING":
+			intents |= types.IntentGuildMessageTyping
+		case "DIRECT_MESSAGES":
+			intents |= types.IntentDirectMessages
+		case "DIRECT_MESSAGE_REACTIONS":
+			intents |= types.IntentDirectMessageReactions
+		case "DIRECT_MESSAGE_TYPING":
+			intents |= types.IntentDirectMessageTyping
+		case "MESSAGE_CONTENT":
+			intents |= types.IntentMessageContent
+		case "GUILD_SCHEDULED_EVENTS":
+			intents |= types.IntentGuildScheduledEvents
+		case "AUTO_MODERATION_CONFIGURATION":
+			intents |= types.IntentAutoModerationConfiguration
+		case "AUTO_MODERATION_EXECUTION":
+			intents |= types.IntentAutoModerationExecution
+		}
+	}
+	return
+}
+
 // LoadEnv loads environment variables into the config, overwriting any existing values
 func (c *Config) LoadEnv() {
 	var v string
@@ -181,6 +245,17 @@ func (c *Config) LoadEnv() {
 		c.Intents = intents
 	}
 
+	v = os.Getenv("DISCORD_DOWNGRADE_INTENTS")
+	if v != "" {
+		intents := strings.Split(v, ",")
+
+		for i, intent := range intents {
+			intents[i] = strings.TrimSpace(intent)
+		}
+
+		c.DowngradeIntents = intents
+	}
+
 	v = os.Getenv("DISCORD_RAW_INTENTS")
 	if v != "" {
 		i, err := strconv.ParseUint(v, 10, 32)
@@ -197,6 +272,29 @@ func (c *Config) LoadEnv() {
 		}
 	}
 
+	v = os.Getenv("RECORD_FILE")
+	if v != "" {
+		c.RecordFile = v
+	}
+
+	v = os.Getenv("READ_ONLY")
+	if v != "" {
+		c.ReadOnly = v == "true"
+	}
+
+	v = os.Getenv("UNKNOWN_EVENTS_FILE")
+	if v != "" {
+		c.UnknownEventsFile = v
+	}
+
+	v = os.Getenv("SHUTDOWN_TIMEOUT")
+	if v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err == nil {
+			c.ShutdownTimeout = duration{timeout}
+		}
+	}
+
 	v = os.Getenv("DISCORD_SHARD_COUNT")
 	if v != "" {
 		i, err := strconv.ParseUint(v, 10, 32)
@@ -217,6 +315,32 @@ func (c *Config) LoadEnv() {
 		}
 	}
 
+	v = os.Getenv("DISCORD_SHARD_TAGS")
+	if v != "" {
+		c.Shards.Tags = parsePairs(v)
+	}
+
+	v = os.Getenv("CANARY_SHARD_IDS")
+	if v != "" {
+		ids := strings.Split(v, ",")
+		c.Canary.Shards = make([]int, 0, len(ids))
+		for _, id := range ids {
+			convID, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				continue
+			}
+			c.Canary.Shards = append(c.Canary.Shards, convID)
+		}
+	}
+
+	v = os.Getenv("CANARY_GATEWAY_VERSION")
+	if v != "" {
+		i, err := strconv.ParseUint(v, 10, 32)
+		if err == nil {
+			c.Canary.GatewayVersion = uint(i)
+		}
+	}
+
 	v = os.Getenv("DISCORD_PRESENCE")
 	if v != "" {
 		var presence types.StatusUpdate
@@ -226,6 +350,36 @@ func (c *Config) LoadEnv() {
 		}
 	}
 
+	v = os.Getenv("RESYNC_PRESENCE_ON_RESUME")
+	if v != "" {
+		c.ResyncPresenceOnResume = v == "true"
+	}
+
+	v = os.Getenv("SHARD_GROUPS")
+	if v != "" {
+		var groups []ShardGroup
+		err := json.Unmarshal([]byte(v), &groups)
+		if err == nil {
+			c.Groups = groups
+		}
+	}
+
+	v = os.Getenv("EVENT_SAMPLE_RATES")
+	if v != "" {
+		c.Sampling = make(map[string]float64)
+		for event, rate := range parsePairs(v) {
+			f, err := strconv.ParseFloat(rate, 64)
+			if err == nil {
+				c.Sampling[event] = f
+			}
+		}
+	}
+
+	v = os.Getenv("GATEWAY_LABELS")
+	if v != "" {
+		c.Labels = parsePairs(v)
+	}
+
 	v = os.Getenv("DISCORD_API_PROTOCOL")
 	if v != "" {
 		c.API.Scheme = v
@@ -249,6 +403,11 @@ func (c *Config) LoadEnv() {
 		c.Broker.Type = v
 	}
 
+	v = os.Getenv("BROKER_ENVELOPE")
+	if v != "" {
+		c.Broker.Envelope = v == "true"
+	}
+
 	v = os.Getenv("BROKER_GROUP")
 	if v != "" {
 		c.Broker.Group = v
@@ -262,6 +421,30 @@ func (c *Config) LoadEnv() {
 		}
 	}
 
+	v = os.Getenv("BROKER_BATCH_MAX_EVENTS")
+	if v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			c.Broker.Batch.MaxEvents = i
+		}
+	}
+
+	v = os.Getenv("BROKER_BATCH_MAX_BYTES")
+	if v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			c.Broker.Batch.MaxBytes = i
+		}
+	}
+
+	v = os.Getenv("BROKER_BATCH_MAX_LATENCY")
+	if v != "" {
+		latency, err := time.ParseDuration(v)
+		if err == nil {
+			c.Broker.Batch.MaxLatency = duration{latency}
+		}
+	}
+
 	v = os.Getenv("PROMETHEUS_ADDRESS")
 	if v != "" {
 		c.Prometheus.Address = v
@@ -272,6 +455,46 @@ func (c *Config) LoadEnv() {
 		c.Prometheus.Endpoint = v
 	}
 
+	v = os.Getenv("PROMETHEUS_TLS_CERT")
+	if v != "" {
+		c.Prometheus.TLSCert = v
+	}
+
+	v = os.Getenv("PROMETHEUS_TLS_KEY")
+	if v != "" {
+		c.Prometheus.TLSKey = v
+	}
+
+	v = os.Getenv("ADMIN_ADDRESS")
+	if v != "" {
+		c.Admin.Address = v
+	}
+
+	v = os.Getenv("ADMIN_TOKEN")
+	if v != "" {
+		c.Admin.Token = v
+	}
+
+	v = os.Getenv("ADMIN_TLS_CERT")
+	if v != "" {
+		c.Admin.TLSCert = v
+	}
+
+	v = os.Getenv("ADMIN_TLS_KEY")
+	if v != "" {
+		c.Admin.TLSKey = v
+	}
+
+	v = os.Getenv("ADMIN_CLIENT_CA")
+	if v != "" {
+		c.Admin.ClientCA = v
+	}
+
+	v = os.Getenv("ADMIN_CHAOS")
+	if v != "" {
+		c.Admin.Chaos = v == "true"
+	}
+
 	v = os.Getenv("SHARD_STORE_TYPE")
 	if v != "" {
 		c.ShardStore.Type = v
@@ -282,6 +505,59 @@ func (c *Config) LoadEnv() {
 		c.ShardStore.Prefix = v
 	}
 
+	v = os.Getenv("SHARD_STORE_ENCRYPTION_KEY")
+	if v != "" {
+		c.ShardStore.EncryptionKey = v
+	}
+
+	v = os.Getenv("SHARD_STORE_WRITE_BEHIND")
+	if v != "" {
+		interval, err := time.ParseDuration(v)
+		if err == nil {
+			c.ShardStore.WriteBehind = duration{interval}
+		}
+	}
+
+	v = os.Getenv("SHARD_STORE_SEQ_PERSIST_EVENTS")
+	if v != "" {
+		events, err := strconv.Atoi(v)
+		if err == nil {
+			c.ShardStore.SeqPersist.Events = events
+		}
+	}
+
+	v = os.Getenv("SHARD_STORE_SEQ_PERSIST_INTERVAL")
+	if v != "" {
+		interval, err := time.ParseDuration(v)
+		if err == nil {
+			c.ShardStore.SeqPersist.Interval = duration{interval}
+		}
+	}
+
+	v = os.Getenv("MAX_RECONNECT_ATTEMPTS")
+	if v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err == nil {
+			c.MaxReconnectAttempts = attempts
+		}
+	}
+
+	v = os.Getenv("EGRESS_BYTES_PER_SECOND")
+	if v != "" {
+		rate, err := strconv.Atoi(v)
+		if err == nil {
+			c.Egress.BytesPerSecond = rate
+		}
+	}
+
+	v = os.Getenv("EGRESS_BURST")
+	if v != "" {
+		burst, err := strconv.Atoi(v)
+		if err == nil {
+			c.Egress.Burst = burst
+		}
+	}
+
 	v = os.Getenv("AMQP_URL")
 	if v != "" {
 		c.AMQP.URL = v
@@ -302,20 +578,46 @@ func (c *Config) LoadEnv() {
 	}
 }
 
+// parsePairs parses a comma-separated list of name=value pairs
+func parsePairs(v string) map[string]string {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return pairs
+}
+
 func (c *Config) String() string {
 	strs := []string{
 		fmt.Sprintf("Events:      %v", c.Events),
 		fmt.Sprintf("Intents:     %v", c.Intents),
 		fmt.Sprintf("Raw intents: %d", c.RawIntents),
+		fmt.Sprintf("Downgrade intents: %v", c.DowngradeIntents),
+		fmt.Sprintf("Read only:   %t", c.ReadOnly),
+		fmt.Sprintf("Unknown events file: %s", c.UnknownEventsFile),
+		fmt.Sprintf("Record file: %s", c.RecordFile),
+		fmt.Sprintf("Shutdown timeout: %s", c.ShutdownTimeout),
 		fmt.Sprintf("Shard count: %d", c.Shards.Count),
 		fmt.Sprintf("Shard IDs:   %v", c.Shards.IDs),
+		fmt.Sprintf("Shard tags:  %v", c.Shards.Tags),
+		fmt.Sprintf("Canary:      %+v", c.Canary),
 		fmt.Sprintf("Broker:      %+v", c.Broker),
-		fmt.Sprintf("Shard store: %+v", c.ShardStore),
+		fmt.Sprintf("Shard store: {Type:%s Prefix:%s Encrypted:%t WriteBehind:%s SeqPersist:%+v}", c.ShardStore.Type, c.ShardStore.Prefix, c.ShardStore.EncryptionKey != "", c.ShardStore.WriteBehind, c.ShardStore.SeqPersist),
+		fmt.Sprintf("Egress:      %+v", c.Egress),
 		fmt.Sprintf("API:         %+v", c.API),
 		fmt.Sprintf("Presence:    %+v", c.Presence),
 		fmt.Sprintf("Activities:  %+v", c.Presence.Activities),
+		fmt.Sprintf("Resync presence on resume: %t", c.ResyncPresenceOnResume),
+		fmt.Sprintf("Max reconnect attempts: %d", c.MaxReconnectAttempts),
+		fmt.Sprintf("Labels:      %v", c.Labels),
+		fmt.Sprintf("Sampling:    %v", c.Sampling),
+		fmt.Sprintf("Groups:      %+v", c.Groups),
 		"",
 		fmt.Sprintf("Prometheus:  %+v", c.Prometheus),
+		fmt.Sprintf("Admin:       {Address:%s Token:%t TLSCert:%s TLSKey:%s ClientCA:%s}", c.Admin.Address, c.Admin.Token != "", c.Admin.TLSCert, c.Admin.TLSKey, c.Admin.ClientCA),
 		fmt.Sprintf("AMQP:        %+v", c.AMQP),
 		fmt.Sprintf("Redis:       %+v", c.Redis),
 	}
@@ -0,0 +1,164 @@
+// Package conformance exercises shards against scripted gateway scenarios using a mock gateway, so
+// that changes to the shard (including in forks) can be verified against the gateway protocol.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/gateway/gateway"
+	"github.com/spec-tacles/gateway/gateway/gatewaytest"
+	"github.com/spec-tacles/go/types"
+)
+
+// DefaultTimeout is the default time to wait for each expected behavior
+const DefaultTimeout = 5 * time.Second
+
+// Options represents Run's options
+type Options struct {
+	// NewShard creates the shard under test from the given options (gateway.NewShard by default)
+	NewShard func(*gateway.ShardOptions) *gateway.Shard
+
+	// Timeout is the time to wait for each expected behavior
+	Timeout time.Duration
+
+	// Scenarios are the scenarios to run (Scenarios by default)
+	Scenarios []Scenario
+
+	// Logger receives the logs of each shard, which are discarded by default
+	Logger *log.Logger
+}
+
+func (opts *Options) init() {
+	if opts.NewShard == nil {
+		opts.NewShard = gateway.NewShard
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.Scenarios == nil {
+		opts.Scenarios = Scenarios
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.New(io.Discard, "", 0)
+	}
+}
+
+// Scenario scripts the gateway's side of an interaction with a shard
+type Scenario struct {
+	Name string
+	Run  func(*Harness) error
+}
+
+// Result represents the outcome of a scenario
+type Result struct {
+	Scenario string
+	Err      error
+	Duration time.Duration
+}
+
+// Passed returns whether the scenario passed
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Run runs every scenario concurrently, each against a new shard and mock gateway, and returns
+// their results in order
+func Run(ctx context.Context, opts Options) []Result {
+	opts.init()
+
+	results := make([]Result, len(opts.Scenarios))
+	var wg sync.WaitGroup
+	for i, sc := range opts.Scenarios {
+		wg.Add(1)
+		go func(i int, sc Scenario) {
+			defer wg.Done()
+
+			start := time.Now()
+			results[i] = Result{
+				Scenario: sc.Name,
+				Err:      run(ctx, &opts, sc),
+				Duration: time.Since(start),
+			}
+		}(i, sc)
+	}
+	wg.Wait()
+	return results
+}
+
+// Report writes the results to w and returns whether every scenario passed
+func Report(w io.Writer, results []Result) (passed bool) {
+	passed = true
+	for _, r := range results {
+		if r.Passed() {
+			fmt.Fprintf(w, "PASS  %-28s %s\n", r.Scenario, r.Duration.Round(time.Millisecond))
+			continue
+		}
+
+		passed = false
+		fmt.Fprintf(w, "FAIL  %-28s %s: %s\n", r.Scenario, r.Duration.Round(time.Millisecond), r.Err)
+	}
+	return
+}
+
+// run runs the scenario against a new shard and mock gateway
+func run(ctx context.Context, opts *Options, sc Scenario) error {
+	ctx, cancel := context.WithCancel(ctx)
+	server := gatewaytest.NewServer()
+
+	shard := opts.NewShard(&gateway.ShardOptions{
+		Identify: &types.Identify{
+			Token: Token,
+			Shard: []int{0, 1},
+		},
+		Retryer:                quickRetryer{},
+		IdentifyLimiter:        gateway.NewDefaultLimiter(1000, time.Second),
+		HelloTimeout:           opts.Timeout / 2,
+		InvalidSessionMinDelay: 10 * time.Millisecond,
+		InvalidSessionMaxDelay: 50 * time.Millisecond,
+		Logger:                 log.New(opts.Logger.Writer(), opts.Logger.Prefix()+"["+sc.Name+"] ", opts.Logger.Flags()),
+		LogLevel:               gateway.LogLevelDebug,
+	})
+	shard.Gateway = &types.GatewayBot{URL: server.URL, Shards: 1}
+
+	h := &Harness{
+		Server:  server,
+		Shard:   shard,
+		Timeout: opts.Timeout,
+		done:    make(chan error, 1),
+	}
+	go func() {
+		h.done <- shard.Open(ctx)
+	}()
+
+	err := sc.Run(h)
+
+	cancel()
+	server.Close()
+	select {
+	case <-h.done:
+	case <-time.After(opts.Timeout):
+		if err == nil {
+			err = fmt.Errorf("shard didn't stop after its context was cancelled")
+		}
+	}
+	return err
+}
+
+// quickRetryer reconnects after a short, constant delay
+type quickRetryer struct{}
+
+func (quickRetryer) FirstTimeout() time.Duration { return 50 * time.Millisecond }
+func (quickRetryer) NextTimeout(timeout time.Duration, retries int) (time.Duration, error) {
+	if retries > 5 {
+		return 0, gateway.ErrMaxRetriesExceeded
+	}
+	return timeout, nil
+}
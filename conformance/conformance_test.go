@@ -0,0 +1,14 @@
+package conformance
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScenarios(t *testing.T) {
+	for _, r := range Run(context.Background(), Options{}) {
+		if !r.Passed() {
+			t.Errorf("%s: %s", r.Scenario, r.Err)
+		}
+	}
+}
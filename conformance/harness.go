@@ -0,0 +1,136 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spec-tacles/gateway/gateway"
+	"github.com/spec-tacles/gateway/gateway/gatewaytest"
+	"github.com/spec-tacles/go/types"
+)
+
+// Token is the token shards under test identify with
+const Token = "conformance-token"
+
+// heartbeatInterval is long enough that heartbeats don't interfere with scenarios that don't test
+// them
+const heartbeatInterval = 45 * time.Second
+
+// Harness connects a scenario to the shard under test
+type Harness struct {
+	Server  *gatewaytest.Server
+	Shard   *gateway.Shard
+	Timeout time.Duration
+
+	done chan error
+}
+
+// Accept waits for the shard to connect
+func (h *Harness) Accept() (*gatewaytest.Conn, error) {
+	c, err := h.Server.Accept(h.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for connection: %w", err)
+	}
+	return c, nil
+}
+
+// ExpectIdentify waits for a valid identify on the connection
+func (h *Harness) ExpectIdentify(c *gatewaytest.Conn) error {
+	p, err := c.Expect(types.GatewayOpIdentify, h.Timeout)
+	if err != nil {
+		return fmt.Errorf("waiting for identify: %w", err)
+	}
+
+	identify := new(types.Identify)
+	if err = json.Unmarshal(p.Data, identify); err != nil {
+		return fmt.Errorf("decoding identify: %w", err)
+	}
+
+	if identify.Token != Token {
+		return fmt.Errorf("identified with the wrong token")
+	}
+	if len(identify.Shard) != 2 || identify.Shard[0] != 0 || identify.Shard[1] != 1 {
+		return fmt.Errorf("identified as shard %v, expected [0 1]", identify.Shard)
+	}
+	return nil
+}
+
+// ExpectResume waits for a resume of the session from the sequence on the connection
+func (h *Harness) ExpectResume(c *gatewaytest.Conn, sessionID string, seq int) error {
+	p, err := c.Expect(types.GatewayOpResume, h.Timeout)
+	if err != nil {
+		return fmt.Errorf("waiting for resume: %w", err)
+	}
+
+	resume := new(types.Resume)
+	if err = json.Unmarshal(p.Data, resume); err != nil {
+		return fmt.Errorf("decoding resume: %w", err)
+	}
+
+	if resume.Token != Token {
+		return fmt.Errorf("resumed with the wrong token")
+	}
+	if resume.SessionID != sessionID {
+		return fmt.Errorf("resumed session %q, expected %q", resume.SessionID, sessionID)
+	}
+	if int(resume.Seq) != seq {
+		return fmt.Errorf("resumed from seq %d, expected %d", resume.Seq, seq)
+	}
+	return nil
+}
+
+// Connect accepts a connection, sends HELLO, and completes an identify with READY (seq 1)
+func (h *Harness) Connect(sessionID string) (*gatewaytest.Conn, error) {
+	c, err := h.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Hello(heartbeatInterval); err != nil {
+		return nil, err
+	}
+
+	if err = h.ExpectIdentify(c); err != nil {
+		return nil, err
+	}
+
+	if err = c.Ready(1, sessionID); err != nil {
+		return nil, err
+	}
+
+	return c, h.Eventually("session to be ready", func() bool {
+		return h.Shard.SessionID() == sessionID
+	})
+}
+
+// Eventually waits for the condition to become true
+func (h *Harness) Eventually(what string, cond func() bool) error {
+	deadline := time.Now().Add(h.Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// ExpectStopped waits for the shard to stop
+func (h *Harness) ExpectStopped() error {
+	select {
+	case <-h.done:
+		h.done <- nil
+		return nil
+	case <-time.After(h.Timeout):
+		return fmt.Errorf("shard didn't stop")
+	}
+}
+
+// ExpectNoConnection verifies that the shard doesn't reconnect within the timeout
+func (h *Harness) ExpectNoConnection() error {
+	if _, err := h.Server.Accept(h.Timeout / 2); err == nil {
+		return fmt.Errorf("shard reconnected")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package conformance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// Scenarios are the default scenarios: identifying, heartbeating, invalid sessions mid-stream,
+// resuming after sequence gaps, unreachable resume URLs, HELLO timeouts, and every close code
+var Scenarios = append([]Scenario{
+	{"identify", identify},
+	{"heartbeat", heartbeat},
+	{"heartbeat-unacknowledged", heartbeatUnacknowledged},
+	{"op9-resumable", invalidSessionResumable},
+	{"op9-not-resumable", invalidSessionNotResumable},
+	{"resume-after-seq-gap", resumeAfterSeqGap},
+	{"resume-url-unreachable", resumeURLUnreachable},
+	{"hello-timeout", helloTimeout},
+}, closeCodeScenarios()...)
+
+func identify(h *Harness) error {
+	_, err := h.Connect("session")
+	return err
+}
+
+func heartbeat(h *Harness) error {
+	c, err := h.Accept()
+	if err != nil {
+		return err
+	}
+
+	if err = c.Hello(100 * time.Millisecond); err != nil {
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = c.Expect(types.GatewayOpHeartbeat, h.Timeout); err != nil {
+			return fmt.Errorf("waiting for heartbeat %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func heartbeatUnacknowledged(h *Harness) error {
+	h.Server.SetAutoAck(false)
+
+	c, err := h.Accept()
+	if err != nil {
+		return err
+	}
+
+	if err = c.Hello(100 * time.Millisecond); err != nil {
+		return err
+	}
+
+	if _, err = h.Accept(); err != nil {
+		return fmt.Errorf("expected a reconnect after unacknowledged heartbeats: %w", err)
+	}
+	return nil
+}
+
+func invalidSessionResumable(h *Harness) error {
+	c, err := h.Connect("session")
+	if err != nil {
+		return err
+	}
+
+	if err = c.Dispatch(2, "TYPING_START", map[string]string{}); err != nil {
+		return err
+	}
+
+	if err = c.InvalidSession(true); err != nil {
+		return err
+	}
+	return h.ExpectResume(c, "session", 2)
+}
+
+func invalidSessionNotResumable(h *Harness) error {
+	c, err := h.Connect("session")
+	if err != nil {
+		return err
+	}
+
+	if err = c.Dispatch(2, "TYPING_START", map[string]string{}); err != nil {
+		return err
+	}
+
+	if err = c.InvalidSession(false); err != nil {
+		return err
+	}
+	return h.ExpectIdentify(c)
+}
+
+func resumeAfterSeqGap(h *Harness) error {
+	c, err := h.Connect("session")
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range []int{2, 3, 10} {
+		if err = c.Dispatch(seq, "TYPING_START", map[string]string{}); err != nil {
+			return err
+		}
+	}
+
+	if err = h.Eventually("seq 10", func() bool { return h.Shard.Seq() == 10 }); err != nil {
+		return err
+	}
+	c.CloseWithCode(types.CloseUnknownError)
+
+	if c, err = h.Accept(); err != nil {
+		return err
+	}
+	if err = c.Hello(heartbeatInterval); err != nil {
+		return err
+	}
+	return h.ExpectResume(c, "session", 10)
+}
+
+// unreachableURL refuses connections
+const unreachableURL = "ws://127.0.0.1:1"
+
+func resumeURLUnreachable(h *Harness) error {
+	c, err := h.Accept()
+	if err != nil {
+		return err
+	}
+	if err = c.Hello(heartbeatInterval); err != nil {
+		return err
+	}
+	if err = h.ExpectIdentify(c); err != nil {
+		return err
+	}
+	if err = c.ReadyResumeURL(1, "session", unreachableURL); err != nil {
+		return err
+	}
+
+	if err = h.Eventually("resume URL", func() bool { return h.Shard.ResumeURL() == unreachableURL }); err != nil {
+		return err
+	}
+	c.CloseWithCode(types.CloseUnknownError)
+
+	if c, err = h.Accept(); err != nil {
+		return fmt.Errorf("expected a fallback to the main gateway URL: %w", err)
+	}
+	if h.Shard.ResumeURL() != "" {
+		return fmt.Errorf("resume URL wasn't cleared")
+	}
+	if err = c.Hello(heartbeatInterval); err != nil {
+		return err
+	}
+	return h.ExpectResume(c, "session", 1)
+}
+
+func helloTimeout(h *Harness) error {
+	if _, err := h.Accept(); err != nil {
+		return err
+	}
+
+	c, err := h.Accept()
+	if err != nil {
+		return fmt.Errorf("expected a reconnect without HELLO: %w", err)
+	}
+
+	if err = c.Hello(heartbeatInterval); err != nil {
+		return err
+	}
+	return h.ExpectIdentify(c)
+}
+
+// closeCodeScenarios return a scenario for each close code, verifying that the shard stops, starts
+// a new session, or resumes the session as appropriate
+func closeCodeScenarios() (scenarios []Scenario) {
+	for code := types.CloseUnknownError; code <= types.CloseDisallowedIntents; code++ {
+		if code == types.CloseAlreadyAuthenticated+1 {
+			continue
+		}
+
+		code := code
+		scenarios = append(scenarios, Scenario{
+			Name: fmt.Sprintf("close-%d", code),
+			Run: func(h *Harness) error {
+				return closeCode(h, code)
+			},
+		})
+	}
+	return
+}
+
+func closeCode(h *Harness, code int) error {
+	c, err := h.Connect("session")
+	if err != nil {
+		return err
+	}
+	c.CloseWithCode(code)
+
+	switch code {
+	case types.CloseAuthenticationFailed, types.CloseInvalidShard, types.CloseShardingRequired,
+		types.CloseInvalidAPIVersion, types.CloseInvalidIntents, types.CloseDisallowedIntents:
+		if err = h.ExpectStopped(); err != nil {
+			return err
+		}
+		return h.ExpectNoConnection()
+	}
+
+	if c, err = h.Accept(); err != nil {
+		return fmt.Errorf("expected a reconnect: %w", err)
+	}
+	if err = c.Hello(heartbeatInterval); err != nil {
+		return err
+	}
+
+	switch code {
+	case types.CloseInvalidSeq, types.CloseSessionTimeout:
+		return h.ExpectIdentify(c)
+	}
+	return h.ExpectResume(c, "session", 1)
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/broker"
+)
+
+// BatchOptions controls when BatchBroker publishes a batch. A batch is published as soon as any
+// configured limit is reached; zero values are ignored.
+type BatchOptions struct {
+	MaxEvents  int
+	MaxBytes   int
+	MaxLatency time.Duration
+
+	// OnError is called with errors from publishing batches in the background
+	OnError func(error)
+}
+
+// BatchBroker wraps a broker, grouping published messages by event and publishing each group as a
+// single message containing an array of the original messages. Published data is copied, so packet
+// buffers may be reused once Publish returns.
+type BatchBroker struct {
+	broker.Broker
+
+	ctx     context.Context
+	opts    BatchOptions
+	mux     sync.Mutex
+	batches map[string]*batch
+}
+
+type batch struct {
+	data  []interface{}
+	bytes int
+	timer *time.Timer
+}
+
+// NewBatchBroker wraps the broker. The context is used for batches published in the background.
+func NewBatchBroker(ctx context.Context, b broker.Broker, opts BatchOptions) *BatchBroker {
+	return &BatchBroker{
+		Broker:  b,
+		ctx:     ctx,
+		opts:    opts,
+		batches: make(map[string]*batch),
+	}
+}
+
+// Publish adds the data to the batch for the event, publishing the batch if it's full
+func (b *BatchBroker) Publish(ctx context.Context, event string, data interface{}) error {
+	b.mux.Lock()
+
+	bt, ok := b.batches[event]
+	if !ok {
+		bt = new(batch)
+		b.batches[event] = bt
+
+		if b.opts.MaxLatency > 0 {
+			bt.timer = time.AfterFunc(b.opts.MaxLatency, func() {
+				if err := b.flushEvent(b.ctx, event, bt, "latency"); err != nil && b.opts.OnError != nil {
+					b.opts.OnError(err)
+				}
+			})
+		}
+	}
+
+	data = retain(data)
+	bt.data = append(bt.data, data)
+	bt.bytes += sizeOf(data)
+
+	var reason string
+	switch {
+	case b.opts.MaxEvents > 0 && len(bt.data) >= b.opts.MaxEvents:
+		reason = "events"
+	case b.opts.MaxBytes > 0 && bt.bytes >= b.opts.MaxBytes:
+		reason = "bytes"
+	}
+	b.mux.Unlock()
+
+	if reason == "" {
+		return nil
+	}
+	return b.flushEvent(ctx, event, bt, reason)
+}
+
+// Pending returns the number of events and bytes waiting to be published
+func (b *BatchBroker) Pending() (events, bytes int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for _, bt := range b.batches {
+		events += len(bt.data)
+		bytes += bt.bytes
+	}
+	return
+}
+
+// Flush publishes every pending batch
+func (b *BatchBroker) Flush(ctx context.Context) (err error) {
+	b.mux.Lock()
+	batches := make(map[string]*batch, len(b.batches))
+	for event, bt := range b.batches {
+		batches[event] = bt
+	}
+	b.mux.Unlock()
+
+	for event, bt := range batches {
+		if flushErr := b.flushEvent(ctx, event, bt, "manual"); flushErr != nil {
+			err = flushErr
+		}
+	}
+	return
+}
+
+// flushEvent publishes the batch if it's still the pending batch for the event
+func (b *BatchBroker) flushEvent(ctx context.Context, event string, bt *batch, reason string) error {
+	b.mux.Lock()
+	if b.batches[event] != bt {
+		b.mux.Unlock()
+		return nil
+	}
+	delete(b.batches, event)
+	b.mux.Unlock()
+
+	if bt.timer != nil {
+		bt.timer.Stop()
+	}
+
+	stats.BatchesPublished.WithLabelValues(reason).Inc()
+	stats.BatchEvents.Observe(float64(len(bt.data)))
+	stats.BatchBytes.Observe(float64(bt.bytes))
+	return b.Broker.Publish(ctx, event, bt.data)
+}
+
+// retain copies published data that references packet buffers, which are only valid until the
+// publish returns
+func retain(data interface{}) interface{} {
+	switch d := data.(type) {
+	case []byte:
+		return append([]byte(nil), d...)
+	case json.RawMessage:
+		return append(json.RawMessage(nil), d...)
+	case *Envelope:
+		e := *d
+		e.Data = append(json.RawMessage(nil), d.Data...)
+		return &e
+	}
+	return data
+}
+
+// sizeOf returns the size in bytes of published data, if known
+func sizeOf(data interface{}) int {
+	switch d := data.(type) {
+	case []byte:
+		return len(d)
+	case json.RawMessage:
+		return len(d)
+	case *Envelope:
+		return len(d.Data)
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/spec-tacles/go/broker"
+)
+
+// publishedBroker records published messages
+type publishedBroker struct {
+	published []interface{}
+}
+
+func (b *publishedBroker) Publish(ctx context.Context, event string, data interface{}) error {
+	b.published = append(b.published, data)
+	return nil
+}
+
+func (b *publishedBroker) Subscribe(ctx context.Context, events []string, messages chan<- broker.Message) error {
+	return nil
+}
+
+func TestBatchBrokerRetainsReusedBuffers(t *testing.T) {
+	pb := new(publishedBroker)
+	b := NewBatchBroker(context.Background(), pb, BatchOptions{MaxEvents: 2})
+
+	buf := make(json.RawMessage, 0, 16)
+	for _, d := range []string{`{"n":1}`, `{"n":2}`} {
+		buf = append(buf[:0], d...)
+		if err := b.Publish(context.Background(), "MESSAGE_CREATE", buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(pb.published) != 1 {
+		t.Fatalf("expected 1 batch, got %d", len(pb.published))
+	}
+
+	batch := pb.published[0].([]interface{})
+	for i, want := range []string{`{"n":1}`, `{"n":2}`} {
+		if got := string(batch[i].(json.RawMessage)); got != want {
+			t.Errorf("payload %d: expected %s, got %s", i, want, got)
+		}
+	}
+}
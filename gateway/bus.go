@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// DefaultSubscriptionQueueSize is the default number of events buffered for each subscriber
+const DefaultSubscriptionQueueSize = 256
+
+// Event is a dispatch delivered to bus subscribers. The same event is delivered to every
+// subscriber, so its packet may be retained but must not be modified.
+type Event struct {
+	ShardID    int
+	ReceivedAt time.Time
+	Packet     *types.ReceivePacket
+}
+
+// SubscriptionOptions represents Bus.Subscribe's options
+type SubscriptionOptions struct {
+	// Name identifies the subscriber in metrics
+	Name string
+
+	// Events and Shards limit the dispatches delivered to the subscriber; empty means all
+	Events []types.GatewayEvent
+	Shards []int
+
+	// QueueSize is the number of events buffered for the subscriber. Events received while the
+	// queue is full are dropped.
+	QueueSize int
+}
+
+func (opts *SubscriptionOptions) init() {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultSubscriptionQueueSize
+	}
+}
+
+// Subscription receives dispatches from a bus on its own queue
+type Subscription struct {
+	// C receives events in the order each shard received them. It's closed by Close.
+	C <-chan *Event
+
+	bus    *Bus
+	name   string
+	c      chan *Event
+	events map[types.GatewayEvent]struct{}
+	shards map[int]struct{}
+}
+
+// Bus delivers dispatches from shards (see ShardOptions.Bus) to any number of in-process
+// subscribers
+type Bus struct {
+	mux  sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus creates an event bus with no subscribers
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber
+func (b *Bus) Subscribe(opts SubscriptionOptions) *Subscription {
+	opts.init()
+
+	c := make(chan *Event, opts.QueueSize)
+	sub := &Subscription{
+		C:    c,
+		bus:  b,
+		name: opts.Name,
+		c:    c,
+	}
+
+	if len(opts.Events) > 0 {
+		sub.events = make(map[types.GatewayEvent]struct{}, len(opts.Events))
+		for _, event := range opts.Events {
+			sub.events[event] = struct{}{}
+		}
+	}
+
+	if len(opts.Shards) > 0 {
+		sub.shards = make(map[int]struct{}, len(opts.Shards))
+		for _, id := range opts.Shards {
+			sub.shards[id] = struct{}{}
+		}
+	}
+
+	b.mux.Lock()
+	b.subs[sub] = struct{}{}
+	b.mux.Unlock()
+	return sub
+}
+
+// Close unsubscribes and closes C. Buffered events can still be received.
+func (s *Subscription) Close() {
+	s.bus.mux.Lock()
+	defer s.bus.mux.Unlock()
+
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+		stats.BusQueueLength.DeleteLabelValues(s.name)
+	}
+}
+
+// Lag returns the number of events waiting to be received
+func (s *Subscription) Lag() int {
+	return len(s.c)
+}
+
+// wants returns whether the subscriber is interested in the dispatch
+func (s *Subscription) wants(shardID int, event types.GatewayEvent) bool {
+	if s.shards != nil {
+		if _, ok := s.shards[shardID]; !ok {
+			return false
+		}
+	}
+
+	if s.events != nil {
+		if _, ok := s.events[event]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribed returns whether any subscriber is interested in the dispatch
+func (b *Bus) subscribed(shardID int, event types.GatewayEvent) bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	for sub := range b.subs {
+		if sub.wants(shardID, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish delivers the dispatch to every interested subscriber without blocking. The packet is
+// copied once for all subscribers, so it may be reused once Publish returns.
+func (b *Bus) Publish(shardID int, p *types.ReceivePacket, receivedAt time.Time) {
+	b.publish(shardID, p, receivedAt, false)
+}
+
+// publish delivers the dispatch to every interested subscriber, copying the packet unless it's
+// already owned by the bus
+func (b *Bus) publish(shardID int, p *types.ReceivePacket, receivedAt time.Time, owned bool) {
+	if p.Op != types.GatewayOpDispatch {
+		return
+	}
+
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	var e *Event
+	for sub := range b.subs {
+		if !sub.wants(shardID, p.Event) {
+			continue
+		}
+
+		if e == nil {
+			if !owned {
+				p = copyPacket(p)
+			}
+			e = &Event{shardID, receivedAt, p}
+		}
+
+		select {
+		case sub.c <- e:
+			stats.BusEvents.WithLabelValues(sub.name, "delivered", strconv.Itoa(shardID)).Inc()
+		default:
+			stats.BusEvents.WithLabelValues(sub.name, "dropped", strconv.Itoa(shardID)).Inc()
+		}
+		stats.BusQueueLength.WithLabelValues(sub.name).Set(float64(len(sub.c)))
+	}
+}
@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/go/types"
+)
+
+// DispatchMode controls how dispatches are divided between OnPacket (and OnPacketContext) and the
+// bus, so that handlers can be migrated to bus subscribers incrementally
+type DispatchMode int
+
+// Dispatch modes
+const (
+	// DispatchBoth delivers every dispatch to OnPacket as well as the bus
+	DispatchBoth DispatchMode = iota
+	// DispatchMigrated delivers dispatches with a bus subscriber only to the bus, and the rest only
+	// to OnPacket
+	DispatchMigrated
+)
+
+// Handle subscribes a handler with the same signature as ManagerOptions.OnPacket, calling it with
+// each event from its own goroutine until the subscription is closed. Unlike OnPacket, the handler
+// may retain the packet, but it's shared with every other subscriber so it must not be modified.
+func (b *Bus) Handle(opts SubscriptionOptions, fn func(shardID int, p *types.ReceivePacket)) *Subscription {
+	sub := b.Subscribe(opts)
+	go func() {
+		for e := range sub.C {
+			fn(e.ShardID, e.Packet)
+		}
+	}()
+	return sub
+}
+
+// copyPacket copies the packet and its data, which is only valid until the next packet is read
+func copyPacket(p *types.ReceivePacket) *types.ReceivePacket {
+	packet := *p
+	packet.Data = append([]byte(nil), p.Data...)
+	return &packet
+}
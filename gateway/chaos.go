@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// chaos holds faults waiting to be injected into the shard's connection
+type chaos struct {
+	dropFrames    int
+	corruptFrames int
+	ackDelay      time.Duration
+	closeCode     int
+}
+
+// DropFrames discards the next n frames received, as if they were lost
+func (s *Shard) DropFrames(n int) {
+	s.chaosMu.Lock()
+	defer s.chaosMu.Unlock()
+
+	s.chaos.dropFrames = n
+}
+
+// CorruptFrames truncates the payload of the next n frames received, so that they fail to decode
+func (s *Shard) CorruptFrames(n int) {
+	s.chaosMu.Lock()
+	defer s.chaosMu.Unlock()
+
+	s.chaos.corruptFrames = n
+}
+
+// DelayAcks delays processing each heartbeat ACK by d until called again with 0
+func (s *Shard) DelayAcks(d time.Duration) {
+	s.chaosMu.Lock()
+	defer s.chaosMu.Unlock()
+
+	s.chaos.ackDelay = d
+}
+
+// ForceClose drops the connection as if Discord closed it with the given code
+func (s *Shard) ForceClose(code int) error {
+	conn, err := s.activeConn()
+	if err != nil {
+		return err
+	}
+
+	s.chaosMu.Lock()
+	s.chaos.closeCode = code
+	s.chaosMu.Unlock()
+
+	stats.ChaosFaults.WithLabelValues("close", s.id).Inc()
+	s.log(LogLevelWarn, "chaos: closing connection with code %d", code)
+	return conn.terminate()
+}
+
+// injectFrameFaults applies pending frame faults to the received frame, returning false if it
+// should be dropped
+func (s *Shard) injectFrameFaults(d []byte) ([]byte, bool) {
+	s.chaosMu.Lock()
+	defer s.chaosMu.Unlock()
+
+	switch {
+	case s.chaos.dropFrames > 0:
+		s.chaos.dropFrames--
+		stats.ChaosFaults.WithLabelValues("drop", s.id).Inc()
+		s.log(LogLevelWarn, "chaos: dropping frame")
+		return nil, false
+
+	case s.chaos.corruptFrames > 0:
+		s.chaos.corruptFrames--
+		stats.ChaosFaults.WithLabelValues("corrupt", s.id).Inc()
+		s.log(LogLevelWarn, "chaos: corrupting frame")
+		return d[:len(d)/2], true
+	}
+	return d, true
+}
+
+// injectReadFault replaces the error from reading a forcibly closed connection with a close error
+func (s *Shard) injectReadFault(err error) error {
+	s.chaosMu.Lock()
+	defer s.chaosMu.Unlock()
+
+	if s.chaos.closeCode == 0 {
+		return err
+	}
+
+	code := s.chaos.closeCode
+	s.chaos.closeCode = 0
+	return &websocket.CloseError{Code: code, Text: "chaos"}
+}
+
+// delayAck schedules a heartbeat ACK to be processed after a delay, if requested, returning whether
+// it was delayed. Only the ACK is delayed, so the read loop carries on handling other packets. ACKs
+// are dropped if their connection closes in the meantime.
+func (s *Shard) delayAck() bool {
+	s.chaosMu.Lock()
+	delay := s.chaos.ackDelay
+	s.chaosMu.Unlock()
+
+	if delay <= 0 {
+		return false
+	}
+	stats.ChaosFaults.WithLabelValues("ack_delay", s.id).Inc()
+
+	conn, err := s.activeConn()
+	if err != nil {
+		return true
+	}
+
+	c, stop := s.after(delay)
+	go func() {
+		defer stop()
+
+		select {
+		case <-c:
+			s.handleHeartbeatAck(conn.done)
+		case <-conn.done:
+		}
+	}()
+	return true
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// discordEpoch is the Unix time in milliseconds from which snowflake timestamps are measured
+const discordEpoch = 1420070400000
+
+// clockSampleInterval is the minimum time between dispatches sampled for event lag
+const clockSampleInterval = time.Second
+
+// clockEvents are the dispatches whose ID is created at the time the event occurs, and so can be
+// used to measure event lag
+var clockEvents = map[types.GatewayEvent]struct{}{
+	"MESSAGE_CREATE":     {},
+	"INTERACTION_CREATE": {},
+}
+
+// ClockStats represents timing information about the packets a shard has received
+type ClockStats struct {
+	// LastReceived is when the most recent packet was received
+	LastReceived time.Time `json:"last_received"`
+
+	// EventLag is the time between events being created, according to their snowflake IDs, and
+	// being received. It includes both upstream delay and any clock skew.
+	EventLag LatencyStats `json:"event_lag"`
+
+	// Skew is the estimated offset of the local clock from Discord's, calculated as the minimum
+	// event lag less half of the heartbeat RTT. Positive values mean the local clock is ahead.
+	Skew time.Duration `json:"skew"`
+}
+
+// receiveClock records packet receive times and samples event lag
+type receiveClock struct {
+	mux          sync.Mutex
+	lastReceived time.Time
+	lastSample   time.Time
+	lags         *latencyRing
+}
+
+// recordReceived records the time the packet was received, sampling its event lag if applicable
+func (s *Shard) recordReceived(p *types.ReceivePacket, at time.Time) {
+	c := &s.clock
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.lastReceived = at
+	if _, ok := clockEvents[p.Event]; !ok || at.Sub(c.lastSample) < clockSampleInterval {
+		return
+	}
+
+	var d struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(p.Data, &d); err != nil {
+		return
+	}
+
+	id, err := strconv.ParseUint(d.ID, 10, 64)
+	if err != nil {
+		return
+	}
+
+	created := time.Unix(0, int64(id>>22+discordEpoch)*int64(time.Millisecond))
+	c.lags.add(at.Sub(created))
+	c.lastSample = at
+}
+
+// Clock returns timing information about the packets the shard has received
+func (s *Shard) Clock() (c ClockStats) {
+	s.clock.mux.Lock()
+	c.LastReceived = s.clock.lastReceived
+	s.clock.mux.Unlock()
+
+	c.EventLag = s.clock.lags.stats()
+	if len(c.EventLag.Samples) > 0 {
+		c.Skew = c.EventLag.Min - s.Latency().Min/2
+	}
+	return
+}
@@ -1,33 +1,165 @@
 package gateway
 
 import (
+	"context"
 	"sync"
 
 	"github.com/gorilla/websocket"
 	"github.com/spec-tacles/gateway/compression"
+	"github.com/spec-tacles/gateway/stats"
 )
 
-// Connection wraps a websocket connection
+// Connection wraps a websocket connection. Reads and writes are each performed by a dedicated
+// goroutine (pump), so that writes never contend with reads and closing is safe at any time.
 type Connection struct {
 	ws         *websocket.Conn
 	compressor compression.Compressor
 	rmux       *sync.Mutex
-	wmux       *sync.Mutex
+
+	// pending contains already-decompressed messages to be returned by Read before any others
+	pending [][]byte
+
+	// reads receives messages from the read pump; readErr is set before readDone is closed when
+	// the read pump stops
+	reads    chan frame
+	readDone chan struct{}
+	readErr  error
+
+	writes chan writeRequest
+
+	// egress limits the rate at which messages are written, if set; egressLabel is the shard label
+	// of its throttle metrics, and egressTime is the time source used to wait for it
+	egress      *ByteLimiter
+	egressLabel string
+	egressTime  TimeSource
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// frame is a message received from the websocket
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+// writeRequest is a message waiting to be written by the write pump
+type writeRequest struct {
+	messageType int
+	data        []byte
+	err         chan error
 }
 
 // NewConnection creates a new ReadWriteCloser wrapper around a connection
 func NewConnection(conn *websocket.Conn, compressor compression.Compressor) (c *Connection) {
-	return &Connection{
+	return NewConnectionContext(context.Background(), conn, compressor)
+}
+
+// NewConnectionContext creates a new ReadWriteCloser wrapper around a connection, which is
+// terminated when the context is done
+func NewConnectionContext(ctx context.Context, conn *websocket.Conn, compressor compression.Compressor) (c *Connection) {
+	c = &Connection{
 		ws:         conn,
 		compressor: compressor,
 		rmux:       &sync.Mutex{},
-		wmux:       &sync.Mutex{},
+		reads:      make(chan frame),
+		readDone:   make(chan struct{}),
+		writes:     make(chan writeRequest),
+		done:       make(chan struct{}),
+	}
+
+	go c.readPump()
+	go c.writePump(ctx)
+	return
+}
+
+// readPump reads messages from the websocket until it fails
+func (c *Connection) readPump() {
+	defer close(c.readDone)
+
+	for {
+		t, d, err := c.ws.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+
+		select {
+		case c.reads <- frame{t, d}:
+		case <-c.done:
+			c.readErr = ErrConnectionClosed
+			return
+		}
+	}
+}
+
+// writePump writes messages to the websocket until the connection is terminated
+func (c *Connection) writePump(ctx context.Context) {
+	for {
+		select {
+		case w := <-c.writes:
+			w.err <- c.ws.WriteMessage(w.messageType, w.data)
+		case <-ctx.Done():
+			c.terminate()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// limitEgress limits the rate at which data is written to the connection. It must be called before
+// the connection is used.
+func (c *Connection) limitEgress(l *ByteLimiter, shard string, ts TimeSource) {
+	c.egress = l
+	c.egressLabel = shard
+	c.egressTime = ts
+}
+
+// throttle waits until the egress limiter allows n bytes to be written. Returns false if the
+// connection is closed while waiting.
+func (c *Connection) throttle(n int) bool {
+	if c.egress == nil {
+		return true
+	}
+
+	delay := c.egress.reserve(c.egressTime.Now(), n)
+	if delay <= 0 {
+		return true
+	}
+	stats.EgressThrottleSeconds.WithLabelValues(c.egressLabel).Add(delay.Seconds())
+
+	t := c.egressTime.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C():
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// write passes the message to the write pump and waits for it to be written, first waiting for the
+// egress limiter if limited is set
+func (c *Connection) write(messageType int, d []byte, limited bool) error {
+	if limited && !c.throttle(len(d)) {
+		return ErrConnectionClosed
+	}
+
+	w := writeRequest{messageType, d, make(chan error, 1)}
+
+	select {
+	case c.writes <- w:
+		return <-w.err
+	case <-c.done:
+		return ErrConnectionClosed
 	}
 }
 
 // CloseWithCode closes the connection with the specified code
 func (c *Connection) CloseWithCode(code int) error {
-	return c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, "Normal Closure"))
+	return c.write(websocket.CloseMessage, websocket.FormatCloseMessage(code, "Normal Closure"), false)
 }
 
 // Close closes this connection
@@ -35,25 +167,54 @@ func (c *Connection) Close() error {
 	return c.CloseWithCode(websocket.CloseNormalClosure)
 }
 
+// terminate closes the underlying network connection without a close handshake, which leaves the
+// session resumable, and stops both pumps
+func (c *Connection) terminate() (err error) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.ws.Close()
+	})
+	return
+}
+
+// unread pushes a decompressed message back onto the connection to be returned by the next Read
+func (c *Connection) unread(d []byte) {
+	c.rmux.Lock()
+	defer c.rmux.Unlock()
+
+	c.pending = append(c.pending, d)
+}
+
 func (c *Connection) Write(d []byte) (int, error) {
 	// d = c.compressor.Compress(d)
 
-	c.wmux.Lock()
-	defer c.wmux.Unlock()
+	return len(d), c.write(websocket.BinaryMessage, d, true)
+}
 
-	return len(d), c.ws.WriteMessage(websocket.BinaryMessage, d)
+// writeUnlimited writes the message without waiting for the egress limiter, for messages such as
+// heartbeats which must not be delayed
+func (c *Connection) writeUnlimited(d []byte) error {
+	return c.write(websocket.BinaryMessage, d, false)
 }
 
 func (c *Connection) Read() (d []byte, err error) {
 	c.rmux.Lock()
 	defer c.rmux.Unlock()
 
-	t, d, err := c.ws.ReadMessage()
-	if err != nil {
+	if len(c.pending) > 0 {
+		d, c.pending = c.pending[0], c.pending[1:]
 		return
 	}
 
-	if t == websocket.BinaryMessage {
+	var f frame
+	select {
+	case f = <-c.reads:
+	case <-c.readDone:
+		return nil, c.readErr
+	}
+
+	d = f.data
+	if f.messageType == websocket.BinaryMessage {
 		d, err = c.compressor.Decompress(d)
 	}
 
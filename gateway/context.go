@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/spec-tacles/go/types"
+)
+
+type contextKey int
+
+const (
+	shardContextKey contextKey = iota
+	receivedAtContextKey
+)
+
+// ShardFromContext returns the shard that received the packet passed to an OnPacketContext handler
+func ShardFromContext(ctx context.Context) (s *Shard, ok bool) {
+	s, ok = ctx.Value(shardContextKey).(*Shard)
+	return
+}
+
+// ReceivedAt returns the time the packet passed to an OnPacketContext handler was received
+func ReceivedAt(ctx context.Context) (t time.Time, ok bool) {
+	t, ok = ctx.Value(receivedAtContextKey).(time.Time)
+	return
+}
+
+// setHandlerContext derives the context passed to OnPacketContext from the context the shard was
+// opened with, adding the shard and ShardOptions.Values
+func (s *Shard) setHandlerContext(ctx context.Context) {
+	ctx = context.WithValue(ctx, shardContextKey, s)
+	for key, value := range s.opts.Values {
+		ctx = context.WithValue(ctx, key, value)
+	}
+
+	s.stateMu.Lock()
+	s.handlerCtx = ctx
+	s.stateMu.Unlock()
+}
+
+// hasHandlers returns whether anything consumes received packets
+func (s *Shard) hasHandlers() bool {
+	return s.opts.OnPacket != nil || s.opts.OnPacketContext != nil || s.opts.Bus != nil
+}
+
+// handle passes the packet to OnPacket, OnPacketContext, and the bus according to DispatchMode.
+// With a bus, each dispatch is copied once and the same copy is shared by every handler, so all of
+// them may retain it and none may modify it.
+func (s *Shard) handle(p *types.ReceivePacket, receivedAt time.Time) {
+	bus := s.opts.Bus
+	if bus == nil || p.Op != types.GatewayOpDispatch {
+		s.handleLegacy(p, receivedAt)
+		return
+	}
+
+	shardID := s.opts.Identify.Shard[0]
+	p = copyPacket(p)
+	if s.opts.DispatchMode != DispatchMigrated || !bus.subscribed(shardID, p.Event) {
+		s.handleLegacy(p, receivedAt)
+	}
+	bus.publish(shardID, p, receivedAt, true)
+}
+
+// handleLegacy passes the packet to OnPacket and OnPacketContext
+func (s *Shard) handleLegacy(p *types.ReceivePacket, receivedAt time.Time) {
+	if s.opts.OnPacket != nil {
+		s.opts.OnPacket(p)
+	}
+
+	if s.opts.OnPacketContext != nil {
+		s.stateMu.RLock()
+		ctx := s.handlerCtx
+		s.stateMu.RUnlock()
+
+		s.opts.OnPacketContext(context.WithValue(ctx, receivedAtContextKey, receivedAt), p)
+	}
+}
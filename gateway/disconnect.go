@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// DisconnectReason categorizes why a connection ended
+type DisconnectReason string
+
+// Disconnect reasons
+const (
+	DisconnectHeartbeatTimeout DisconnectReason = "heartbeat_timeout"
+	DisconnectReconnect        DisconnectReason = "reconnect"
+	DisconnectInvalidSession   DisconnectReason = "invalid_session"
+	DisconnectLocal            DisconnectReason = "local"
+	DisconnectRemote           DisconnectReason = "remote"
+	DisconnectNetworkError     DisconnectReason = "network_error"
+)
+
+// disconnectReason categorizes the error that ended a connection. The close code is 0 if the
+// connection didn't end with a close frame.
+func (s *Shard) disconnectReason(err error) (code int, reason DisconnectReason) {
+	closeErr := new(websocket.CloseError)
+	if errors.As(err, &closeErr) {
+		code = closeErr.Code
+	}
+
+	s.stateMu.RLock()
+	closeReason := s.closeReason
+	s.stateMu.RUnlock()
+
+	switch {
+	case errors.Is(closeReason, ErrHeartbeatUnacknowledged):
+		reason = DisconnectHeartbeatTimeout
+	case errors.Is(closeReason, ErrReconnectReceived):
+		reason = DisconnectReconnect
+	case errors.Is(closeReason, ErrInvalidSession):
+		reason = DisconnectInvalidSession
+	case closeReason != nil:
+		reason = DisconnectLocal
+	case code != 0:
+		reason = DisconnectRemote
+	default:
+		reason = DisconnectNetworkError
+	}
+	return
+}
+
+// setCloseReason records why the current connection is expected to end
+func (s *Shard) setCloseReason(reason error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.closeReason = reason
+}
+
+// recordDisconnect records metrics for a connection that ended with the given error, returning its
+// close code and reason
+func (s *Shard) recordDisconnect(err error) (code int, reason DisconnectReason) {
+	code, reason = s.disconnectReason(err)
+	stats.Disconnects.WithLabelValues(strconv.Itoa(code), string(reason), s.id).Inc()
+	s.setCloseReason(nil)
+	return
+}
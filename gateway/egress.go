@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ByteLimiter limits the rate at which bytes are written to connections, so that gateway processes
+// sharing a constrained or metered link can cap their bandwidth. It's independent of the packet
+// ratelimit and can be shared by many shards to cap their combined egress.
+type ByteLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewByteLimiter creates a limiter allowing bytesPerSecond on average, with bursts of up to burst
+// bytes. If burst isn't positive, it defaults to bytesPerSecond.
+func NewByteLimiter(bytesPerSecond, burst int) (*ByteLimiter, error) {
+	if bytesPerSecond <= 0 {
+		return nil, ErrInvalidByteRate
+	}
+
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+
+	return &ByteLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}, nil
+}
+
+// reserve takes n bytes from the limiter at the given time, returning how long to wait before
+// writing them. Messages larger than the burst are allowed once the limiter has accrued the
+// difference.
+func (l *ByteLimiter) reserve(now time.Time, n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last.IsZero() {
+		l.last = now
+	}
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"encoding/json"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// Envelope wraps a dispatch published to the broker with metadata about the shard that received it.
+// GuildID and ChannelID are copied from the top level of the dispatch data, if present, so that
+// consumers can route and filter events without parsing them. Backfill is set on events replayed
+// from a recording (see Manager.Backfill).
+type Envelope struct {
+	ShardID   int               `json:"shard_id"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	GuildID   string            `json:"guild_id,omitempty"`
+	ChannelID string            `json:"channel_id,omitempty"`
+	Backfill  bool              `json:"backfill,omitempty"`
+	Data      json.RawMessage   `json:"d"`
+}
+
+// envelope wraps the dispatch received by the given shard
+func (m *Manager) envelope(shardID int, p *types.ReceivePacket) *Envelope {
+	e := &Envelope{
+		ShardID: shardID,
+		Data:    p.Data,
+	}
+
+	if s := m.Shard(shardID); s != nil {
+		e.Tags = s.Tags()
+	}
+
+	e.GuildID, e.ChannelID = routingKeys(p.Data)
+	return e
+}
+
+// routingKeys extracts the guild and channel IDs from the top level of the dispatch data
+func routingKeys(data []byte) (guildID, channelID string) {
+	scanObject(data, func(key, value []byte) bool {
+		switch string(key) {
+		case "guild_id":
+			guildID = string(scanString(value))
+		case "channel_id":
+			channelID = string(scanString(value))
+		}
+		return guildID == "" || channelID == ""
+	})
+	return
+}
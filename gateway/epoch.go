@@ -0,0 +1,64 @@
+package gateway
+
+// Epoch returns the generation of the shard's current connection. It increases every time a new
+// connection is established, so callers can detect that a connection they were using has been
+// replaced. Zero means the shard has never connected.
+func (s *Shard) Epoch() uint64 {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	return s.epoch
+}
+
+// setConn makes the connection active and returns its epoch
+func (s *Shard) setConn(conn *Connection) uint64 {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	s.conn = conn
+	s.epoch++
+	return s.epoch
+}
+
+// clearConn removes the active connection if it's still from the given epoch, returning it
+func (s *Shard) clearConn(epoch uint64) *Connection {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.epoch != epoch || s.conn == nil {
+		return nil
+	}
+
+	conn := s.conn
+	s.conn = nil
+	return conn
+}
+
+// activeConn returns the active connection, or ErrNotConnected if there is none
+func (s *Shard) activeConn() (*Connection, error) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return s.conn, nil
+}
+
+// connected returns whether the shard has a connection with a session
+func (s *Shard) connected() bool {
+	_, err := s.activeConn()
+	return err == nil && s.SessionID() != ""
+}
+
+// epochConn returns the active connection if it's from the given epoch, or ErrNotConnected if it
+// has been replaced
+func (s *Shard) epochConn(epoch uint64) (*Connection, error) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.conn == nil || s.epoch != epoch {
+		return nil, ErrNotConnected
+	}
+	return s.conn, nil
+}
@@ -8,5 +8,19 @@ var (
 	ErrHeartbeatUnacknowledged = errors.New("heartbeat was never acknowledged")
 	ErrMaxRetriesExceeded      = errors.New("max retries exceeded")
 	ErrReconnectReceived       = errors.New("received reconnect OP code")
+	ErrReidentifyRequested     = errors.New("new session requested")
+	ErrInvalidSession          = errors.New("received invalid session OP code")
+	ErrRepeatedInvalidSession  = errors.New("repeatedly received invalid session OP code during startup (check shard count and intents)")
 	ErrConnectionClosed        = errors.New("connection was closed")
+	ErrShardClosing            = errors.New("shard is closing")
+	ErrSendQueueFull           = errors.New("send queue is full")
+	ErrNotConnected            = errors.New("shard is not connected")
+	ErrManagerNotStarted       = errors.New("manager is not started")
+	ErrReadOnly                = errors.New("shard is read-only")
+	ErrNoBroker                = errors.New("no broker is connected")
+	ErrHelloTimeout            = errors.New("timed out waiting for HELLO")
+	ErrCiphertextTooShort      = errors.New("stored ciphertext is too short")
+	ErrUndecryptableSession    = errors.New("stored session can't be decrypted")
+	ErrUnexpectedPacket        = errors.New("received an unexpected packet")
+	ErrInvalidByteRate         = errors.New("byte rate must be positive")
 )
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// KnownEvents contains every dispatch event name this package recognizes. Dispatches for any other
+// event are counted as unknown and passed to ShardOptions.OnUnknownEvent. Events may be added to
+// this set before any shards are opened.
+var KnownEvents = map[types.GatewayEvent]struct{}{
+	"READY":                                  {},
+	"RESUMED":                                {},
+	"APPLICATION_COMMAND_PERMISSIONS_UPDATE": {},
+	"AUTO_MODERATION_RULE_CREATE":            {},
+	"AUTO_MODERATION_RULE_UPDATE":            {},
+	"AUTO_MODERATION_RULE_DELETE":            {},
+	"AUTO_MODERATION_ACTION_EXECUTION":       {},
+	"CHANNEL_CREATE":                         {},
+	"CHANNEL_UPDATE":                         {},
+	"CHANNEL_DELETE":                         {},
+	"CHANNEL_PINS_UPDATE":                    {},
+	"THREAD_CREATE":                          {},
+	"THREAD_UPDATE":                          {},
+	"THREAD_DELETE":                          {},
+	"THREAD_LIST_SYNC":                       {},
+	"THREAD_MEMBER_UPDATE":                   {},
+	"THREAD_MEMBERS_UPDATE":                  {},
+	"ENTITLEMENT_CREATE":                     {},
+	"ENTITLEMENT_UPDATE":                     {},
+	"ENTITLEMENT_DELETE":                     {},
+	"GUILD_CREATE":                           {},
+	"GUILD_UPDATE":                           {},
+	"GUILD_DELETE":                           {},
+	"GUILD_AUDIT_LOG_ENTRY_CREATE":           {},
+	"GUILD_BAN_ADD":                          {},
+	"GUILD_BAN_REMOVE":                       {},
+	"GUILD_EMOJIS_UPDATE":                    {},
+	"GUILD_STICKERS_UPDATE":                  {},
+	"GUILD_INTEGRATIONS_UPDATE":              {},
+	"GUILD_MEMBER_ADD":                       {},
+	"GUILD_MEMBER_REMOVE":                    {},
+	"GUILD_MEMBER_UPDATE":                    {},
+	"GUILD_MEMBERS_CHUNK":                    {},
+	"GUILD_ROLE_CREATE":                      {},
+	"GUILD_ROLE_UPDATE":                      {},
+	"GUILD_ROLE_DELETE":                      {},
+	"GUILD_SCHEDULED_EVENT_CREATE":           {},
+	"GUILD_SCHEDULED_EVENT_UPDATE":           {},
+	"GUILD_SCHEDULED_EVENT_DELETE":           {},
+	"GUILD_SCHEDULED_EVENT_USER_ADD":         {},
+	"GUILD_SCHEDULED_EVENT_USER_REMOVE":      {},
+	"INTEGRATION_CREATE":                     {},
+	"INTEGRATION_UPDATE":                     {},
+	"INTEGRATION_DELETE":                     {},
+	"INTERACTION_CREATE":                     {},
+	"INVITE_CREATE":                          {},
+	"INVITE_DELETE":                          {},
+	"MESSAGE_CREATE":                         {},
+	"MESSAGE_UPDATE":                         {},
+	"MESSAGE_DELETE":                         {},
+	"MESSAGE_DELETE_BULK":                    {},
+	"MESSAGE_REACTION_ADD":                   {},
+	"MESSAGE_REACTION_REMOVE":                {},
+	"MESSAGE_REACTION_REMOVE_ALL":            {},
+	"MESSAGE_REACTION_REMOVE_EMOJI":          {},
+	"GUILD_SOUNDBOARD_SOUND_CREATE":          {},
+	"GUILD_SOUNDBOARD_SOUND_UPDATE":          {},
+	"GUILD_SOUNDBOARD_SOUND_DELETE":          {},
+	"GUILD_SOUNDBOARD_SOUNDS_UPDATE":         {},
+	"SOUNDBOARD_SOUNDS":                      {},
+	"PRESENCE_UPDATE":                        {},
+	"STAGE_INSTANCE_CREATE":                  {},
+	"STAGE_INSTANCE_UPDATE":                  {},
+	"STAGE_INSTANCE_DELETE":                  {},
+	"TYPING_START":                           {},
+	"USER_UPDATE":                            {},
+	"VOICE_STATE_UPDATE":                     {},
+	"VOICE_SERVER_UPDATE":                    {},
+	"WEBHOOKS_UPDATE":                        {},
+}
+
+// trackUnknownEvent counts the dispatch and passes it to OnUnknownEvent if its event isn't known
+func (s *Shard) trackUnknownEvent(p *types.ReceivePacket) {
+	if _, ok := KnownEvents[p.Event]; ok {
+		return
+	}
+
+	stats.UnknownEvents.WithLabelValues(string(p.Event), s.id).Inc()
+	s.log(LogLevelDebug, "received unknown event %s", p.Event)
+
+	if s.opts.OnUnknownEvent != nil {
+		s.opts.OnUnknownEvent(p)
+	}
+}
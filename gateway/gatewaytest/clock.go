@@ -0,0 +1,156 @@
+package gatewaytest
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/gateway/gateway"
+)
+
+// Clock is a gateway.TimeSource whose time only moves when advanced, so that heartbeats, backoff,
+// and invalid session delays fire instantly and in a deterministic order
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending timer or ticker
+type waiter struct {
+	clock  *Clock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+// NewClock creates a clock starting at the given time
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's current time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer which fires once the clock has been advanced by d
+func (c *Clock) NewTimer(d time.Duration) gateway.Timer {
+	return c.add(d, 0, nil)
+}
+
+// NewTicker creates a ticker which fires each time the clock is advanced past another d
+func (c *Clock) NewTicker(d time.Duration) gateway.Ticker {
+	return ticker{c.add(d, d, nil)}
+}
+
+// AfterFunc calls f in its own goroutine once the clock has been advanced by d
+func (c *Clock) AfterFunc(d time.Duration, f func()) gateway.Timer {
+	return c.add(d, 0, f)
+}
+
+// Pending returns the number of timers and tickers that haven't fired or been stopped
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Advance moves the clock forward by d, firing every timer and tick due in the meantime in order.
+// Like real tickers, a tick is dropped if the previous one hasn't been received.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+		if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+			break
+		}
+
+		w := c.waiters[0]
+		c.now = w.at
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+
+		if w.f != nil {
+			go w.f()
+			continue
+		}
+
+		select {
+		case w.c <- c.now:
+		default:
+		}
+	}
+	c.now = end
+}
+
+func (c *Clock) add(d, period time.Duration, f func()) *waiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &waiter{clock: c, at: c.now.Add(d), period: period, f: f}
+	if f == nil {
+		w.c = make(chan time.Time, 1)
+	}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// C returns the channel on which the time is delivered
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+// Stop prevents the waiter from firing, returning false if it already fired or was stopped
+func (w *waiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	for i, o := range w.clock.waiters {
+		if o == w {
+			w.clock.waiters = append(w.clock.waiters[:i], w.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ticker adapts a waiter to gateway.Ticker
+type ticker struct{ *waiter }
+
+func (t ticker) Stop() { t.waiter.Stop() }
+
+// Random is a gateway.Random with a fixed seed which is safe for concurrent use
+type Random struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRandom creates a source of random numbers which always produces the same sequence for a seed
+func NewRandom(seed int64) *Random {
+	return &Random{r: rand.New(rand.NewSource(seed))}
+}
+
+// Int63n returns a number in [0, n)
+func (r *Random) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
+
+// Float64 returns a number in [0, 1)
+func (r *Random) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
@@ -0,0 +1,215 @@
+// Package gatewaytest provides a mock Discord gateway for exercising shards.
+package gatewaytest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spec-tacles/go/types"
+)
+
+// Errors
+var (
+	ErrTimeout = errors.New("timed out")
+	ErrClosed  = errors.New("connection closed")
+)
+
+// Server is a mock Discord gateway. Each connection is sent on Conns, uncompressed.
+type Server struct {
+	// URL is the websocket URL of the server, for use as types.GatewayBot.URL
+	URL   string
+	Conns chan *Conn
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	autoAckMu sync.Mutex
+	autoAck   bool
+}
+
+// NewServer starts a mock gateway which acknowledges heartbeats
+func NewServer() *Server {
+	s := &Server{
+		Conns:   make(chan *Conn, 16),
+		autoAck: true,
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.srv.URL, "http")
+	return s
+}
+
+// Close closes the server and every connection
+func (s *Server) Close() {
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// SetAutoAck sets whether heartbeats from every connection are acknowledged automatically
+func (s *Server) SetAutoAck(autoAck bool) {
+	s.autoAckMu.Lock()
+	defer s.autoAckMu.Unlock()
+	s.autoAck = autoAck
+}
+
+func (s *Server) autoAcking() bool {
+	s.autoAckMu.Lock()
+	defer s.autoAckMu.Unlock()
+	return s.autoAck
+}
+
+// Accept waits for the next connection
+func (s *Server) Accept(timeout time.Duration) (*Conn, error) {
+	select {
+	case c := <-s.Conns:
+		return c, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &Conn{
+		ws:      ws,
+		server:  s,
+		packets: make(chan *Packet, 64),
+		closed:  make(chan struct{}),
+	}
+	go c.read()
+	s.Conns <- c
+}
+
+// Packet is a packet received from a shard
+type Packet struct {
+	Op   types.GatewayOp `json:"op"`
+	Data json.RawMessage `json:"d"`
+}
+
+// Conn is a connection from a shard to the mock gateway
+type Conn struct {
+	ws      *websocket.Conn
+	wmux    sync.Mutex
+	server  *Server
+	packets chan *Packet
+	closed  chan struct{}
+}
+
+// read receives packets until the connection closes
+func (c *Conn) read() {
+	defer close(c.closed)
+
+	for {
+		_, d, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		p := new(Packet)
+		if err = json.Unmarshal(d, p); err != nil {
+			continue
+		}
+
+		if p.Op == types.GatewayOpHeartbeat && c.server.autoAcking() {
+			c.Send(types.GatewayOpHeartbeatACK, 0, "", nil)
+		}
+
+		select {
+		case c.packets <- p:
+		default:
+		}
+	}
+}
+
+// Send sends a packet to the shard
+func (c *Conn) Send(op types.GatewayOp, seq int, event types.GatewayEvent, data interface{}) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	return c.ws.WriteJSON(&types.ReceivePacket{
+		Op:    op,
+		Data:  d,
+		Seq:   types.Seq(seq),
+		Event: event,
+	})
+}
+
+// Hello sends HELLO with the given heartbeat interval
+func (c *Conn) Hello(interval time.Duration) error {
+	return c.Send(types.GatewayOpHello, 0, "", &types.Hello{
+		HeartbeatInterval: int64(interval / time.Millisecond),
+	})
+}
+
+// Dispatch sends a dispatch
+func (c *Conn) Dispatch(seq int, event types.GatewayEvent, data interface{}) error {
+	return c.Send(types.GatewayOpDispatch, seq, event, data)
+}
+
+// Ready sends READY for the given session
+func (c *Conn) Ready(seq int, sessionID string) error {
+	return c.ReadyResumeURL(seq, sessionID, "")
+}
+
+// ReadyResumeURL sends READY for the given session, with the URL to use for resuming it
+func (c *Conn) ReadyResumeURL(seq int, sessionID, resumeURL string) error {
+	ready := map[string]interface{}{
+		"v":          10,
+		"session_id": sessionID,
+	}
+	if resumeURL != "" {
+		ready["resume_gateway_url"] = resumeURL
+	}
+	return c.Dispatch(seq, types.GatewayEventReady, ready)
+}
+
+// InvalidSession sends an invalid session packet
+func (c *Conn) InvalidSession(resumable bool) error {
+	return c.Send(types.GatewayOpInvalidSession, 0, "", resumable)
+}
+
+// Expect waits for a packet with the given operation, discarding any others
+func (c *Conn) Expect(op types.GatewayOp, timeout time.Duration) (*Packet, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p := <-c.packets:
+			if p.Op == op {
+				return p, nil
+			}
+		case <-c.closed:
+			return nil, ErrClosed
+		case <-deadline:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// CloseWithCode closes the connection with the given close code
+func (c *Conn) CloseWithCode(code int) error {
+	c.wmux.Lock()
+	err := c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+	c.wmux.Unlock()
+
+	c.ws.Close()
+	return err
+}
+
+// Closed is closed once the connection has closed
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// GuildRoute represents the shard serving a guild. Labels identify the gateway process and Tags the
+// shard, so that services in distributed deployments can locate where a guild's events come from.
+type GuildRoute struct {
+	GuildID uint64            `json:"guild_id,string"`
+	ShardID int               `json:"shard_id"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// trackGuild reports guilds joining and leaving the shard according to GUILD_CREATE and
+// GUILD_DELETE dispatches. Guilds deleted because of an outage (unavailable) are still served by
+// the shard.
+func (s *Shard) trackGuild(p *types.ReceivePacket) {
+	if s.opts.onGuild == nil {
+		return
+	}
+
+	var (
+		id          uint64
+		unavailable bool
+	)
+	scanObject(p.Data, func(key, value []byte) bool {
+		switch string(key) {
+		case "id":
+			id, _ = strconv.ParseUint(string(scanString(value)), 10, 64)
+		case "unavailable":
+			unavailable = string(value) == "true"
+		}
+		// GUILD_CREATE can be large, and only its ID is needed
+		return id == 0 || p.Event != "GUILD_CREATE"
+	})
+	if id == 0 {
+		return
+	}
+
+	s.opts.onGuild(id, p.Event == "GUILD_CREATE" || unavailable)
+}
+
+// guildChanged records whether the guild is served by the given shard
+func (m *Manager) guildChanged(shardID int, guildID uint64, joined bool) {
+	m.guildsMu.Lock()
+	defer m.guildsMu.Unlock()
+
+	if joined {
+		m.guilds[guildID] = shardID
+	} else if m.guilds[guildID] == shardID {
+		delete(m.guilds, guildID)
+	}
+}
+
+// forgetGuilds removes the guilds served by a shard which has stopped
+func (m *Manager) forgetGuilds(shardID int) {
+	m.guildsMu.Lock()
+	defer m.guildsMu.Unlock()
+
+	for guildID, id := range m.guilds {
+		if id == shardID {
+			delete(m.guilds, guildID)
+		}
+	}
+}
+
+// GuildShard returns the ID of the shard serving the guild, and whether any running shard has
+// received the guild
+func (m *Manager) GuildShard(guildID uint64) (shardID int, ok bool) {
+	m.guildsMu.RLock()
+	defer m.guildsMu.RUnlock()
+
+	shardID, ok = m.guilds[guildID]
+	return
+}
+
+// GuildRoute returns the route to the guild, and whether any running shard has received the guild
+func (m *Manager) GuildRoute(guildID uint64) (r GuildRoute, ok bool) {
+	shardID, ok := m.GuildShard(guildID)
+	if !ok {
+		return
+	}
+	return m.guildRoute(guildID, shardID), true
+}
+
+// GuildRoutes returns the route to every guild served by the manager's shards, ordered by guild ID
+func (m *Manager) GuildRoutes() []GuildRoute {
+	m.guildsMu.RLock()
+	routes := make([]GuildRoute, 0, len(m.guilds))
+	for guildID, shardID := range m.guilds {
+		routes = append(routes, GuildRoute{GuildID: guildID, ShardID: shardID})
+	}
+	m.guildsMu.RUnlock()
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i].GuildID < routes[j].GuildID })
+	for i, r := range routes {
+		routes[i] = m.guildRoute(r.GuildID, r.ShardID)
+	}
+	return routes
+}
+
+func (m *Manager) guildRoute(guildID uint64, shardID int) GuildRoute {
+	r := GuildRoute{
+		GuildID: guildID,
+		ShardID: shardID,
+		Labels:  m.opts.Labels,
+	}
+
+	if s := m.Shard(shardID); s != nil {
+		r.Tags = s.Tags()
+	}
+	return r
+}
@@ -0,0 +1,32 @@
+package gateway
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/spec-tacles/go/types"
+)
+
+// PrivilegedIntents are the intents which must be enabled for the application in the developer
+// portal
+const PrivilegedIntents = types.IntentGuildMembers | types.IntentGuildPresences | types.IntentMessageContent
+
+// downgradeIntents removes the allowed intents from the identify if Discord disallowed them, and
+// returns whether the shard should identify again
+func (s *Shard) downgradeIntents(err error) bool {
+	if !websocket.IsCloseError(err, types.CloseDisallowedIntents) {
+		return false
+	}
+
+	intents := uint(s.opts.Identify.Intents)
+	removed := intents & s.opts.DowngradeIntents
+	if removed == 0 {
+		return false
+	}
+
+	s.opts.Identify.Intents = int(intents &^ removed)
+	s.stateMu.Lock()
+	s.identifyNext = true
+	s.stateMu.Unlock()
+
+	s.log(LogLevelError, "!!! disallowed intents: identifying without intents %d (now %d); enable them in the developer portal to receive their events !!!", removed, s.opts.Identify.Intents)
+	return true
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"context"
+	"time"
+)
+
+// Default invalid session handling
+const (
+	DefaultInvalidSessionMinDelay    = time.Second
+	DefaultInvalidSessionMaxDelay    = 5 * time.Second
+	DefaultMaxStartupInvalidSessions = 5
+)
+
+// handleInvalidSession schedules a new identify after a random delay without blocking the read
+// loop. Returns ErrRepeatedInvalidSession if the shard has been invalidated too many times without
+// ever establishing a session, which usually indicates a sharding or intents misconfiguration.
+func (s *Shard) handleInvalidSession(ctx context.Context) error {
+	s.stateMu.Lock()
+	s.invalidSessions++
+	count, started := s.invalidSessions, s.started
+	s.stateMu.Unlock()
+
+	if !started && s.opts.MaxStartupInvalidSessions > 0 && count >= s.opts.MaxStartupInvalidSessions {
+		return ErrRepeatedInvalidSession
+	}
+
+	delay := s.opts.InvalidSessionMinDelay
+	if spread := s.opts.InvalidSessionMaxDelay - s.opts.InvalidSessionMinDelay; spread > 0 {
+		delay += time.Duration(s.opts.Random.Int63n(int64(spread)))
+	}
+
+	epoch := s.Epoch()
+	s.log(LogLevelDebug, "Identifying in %s in response to invalid non-resumable session", delay)
+
+	go func() {
+		c, stop := s.after(delay)
+		defer stop()
+
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return
+		}
+
+		// the connection may have been replaced while waiting, in which case it identifies on its
+		// own
+		if _, err := s.epochConn(epoch); err != nil {
+			return
+		}
+
+		if err := s.sendIdentify(); err != nil {
+			s.log(LogLevelError, "error identifying after invalid session: %s", err)
+			return
+		}
+		s.log(LogLevelDebug, "Sent identify in response to invalid non-resumable session")
+	}()
+	return nil
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencySamples is the default number of heartbeat RTTs kept per shard
+const DefaultLatencySamples = 32
+
+// LatencyStats represents statistics over a shard's recent heartbeat RTTs
+type LatencyStats struct {
+	Min time.Duration `json:"min"`
+	Avg time.Duration `json:"avg"`
+	P95 time.Duration `json:"p95"`
+	Max time.Duration `json:"max"`
+
+	// Trend is the difference between the averages of the newer and older halves of the samples;
+	// positive values mean latency is increasing
+	Trend time.Duration `json:"trend"`
+
+	// Samples contains the raw RTTs, oldest first
+	Samples []time.Duration `json:"samples"`
+}
+
+// latencyRing is a fixed-size ring buffer of heartbeat RTTs
+type latencyRing struct {
+	mux     sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyRing(size int) *latencyRing {
+	return &latencyRing{
+		samples: make([]time.Duration, size),
+	}
+}
+
+// add records an RTT, overwriting the oldest sample when the ring is full
+func (r *latencyRing) add(rtt time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.samples[r.next] = rtt
+	r.next++
+	if r.next == len(r.samples) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// stats calculates statistics over the recorded samples
+func (r *latencyRing) stats() (s LatencyStats) {
+	r.mux.Lock()
+	if r.full {
+		s.Samples = make([]time.Duration, 0, len(r.samples))
+		s.Samples = append(s.Samples, r.samples[r.next:]...)
+	}
+	s.Samples = append(s.Samples, r.samples[:r.next]...)
+	r.mux.Unlock()
+
+	if len(s.Samples) == 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, len(s.Samples))
+	copy(sorted, s.Samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, rtt := range sorted {
+		total += rtt
+	}
+
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	s.Avg = total / time.Duration(len(sorted))
+	s.P95 = sorted[(len(sorted)*95+99)/100-1]
+
+	if half := len(s.Samples) / 2; half > 0 {
+		var older, newer time.Duration
+		for _, rtt := range s.Samples[:half] {
+			older += rtt
+		}
+		for _, rtt := range s.Samples[len(s.Samples)-half:] {
+			newer += rtt
+		}
+		s.Trend = (newer - older) / time.Duration(half)
+	}
+	return
+}
+
+// Latency returns statistics over the shard's recent heartbeat RTTs
+func (s *Shard) Latency() LatencyStats {
+	return s.latency.stats()
+}
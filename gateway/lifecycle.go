@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// LifecycleEventType is a kind of shard lifecycle event
+type LifecycleEventType string
+
+// Lifecycle event types
+const (
+	// LifecycleConnecting is emitted whenever the shard starts connecting
+	LifecycleConnecting LifecycleEventType = "connecting"
+	// LifecycleReady is emitted when a new session starts (READY)
+	LifecycleReady LifecycleEventType = "ready"
+	// LifecycleResumed is emitted when a session is resumed (RESUMED)
+	LifecycleResumed LifecycleEventType = "resumed"
+	// LifecycleDisconnected is emitted whenever a connection ends, or fails to be established
+	LifecycleDisconnected LifecycleEventType = "disconnected"
+	// LifecycleGaveUp is emitted when the shard stops reconnecting because of an unrecoverable
+	// close or too many failed attempts. It isn't emitted when the shard is closed or its context
+	// is done.
+	LifecycleGaveUp LifecycleEventType = "gave_up"
+)
+
+// LifecycleEvent represents a change in a shard's connection, for consumption by supervisors,
+// dashboards, and alerting
+type LifecycleEvent struct {
+	Type    LifecycleEventType `json:"type"`
+	ShardID int                `json:"shard_id"`
+	Time    time.Time          `json:"time"`
+
+	// Code, Reason, and Resumable describe disconnects. Code is 0 if the connection ended without a
+	// close frame, and Resumable is whether the shard will try to resume the session rather than
+	// start a new one or give up.
+	Code      int              `json:"code,omitempty"`
+	Reason    DisconnectReason `json:"reason,omitempty"`
+	Resumable bool             `json:"resumable"`
+
+	// Err is the error which ended the connection or made the shard give up, if any
+	Err error `json:"-"`
+}
+
+// emitLifecycle passes the event to OnLifecycle
+func (s *Shard) emitLifecycle(e LifecycleEvent) {
+	if s.opts.OnLifecycle == nil {
+		return
+	}
+
+	e.ShardID = s.opts.Identify.Shard[0]
+	e.Time = s.opts.TimeSource.Now()
+	s.opts.OnLifecycle(e)
+}
+
+// emitDisconnected emits a disconnect which ended with the given error
+func (s *Shard) emitDisconnected(err error, code int, reason DisconnectReason, recoverable bool) {
+	s.stateMu.RLock()
+	resumable := recoverable && !s.closing && s.sessionID != "" && !s.identifyNext
+	s.stateMu.RUnlock()
+
+	s.emitLifecycle(LifecycleEvent{
+		Type:      LifecycleDisconnected,
+		Code:      code,
+		Reason:    reason,
+		Resumable: resumable,
+		Err:       err,
+	})
+}
+
+// LifecycleEvents returns a channel receiving the lifecycle events of every shard, and a function
+// which stops them and closes the channel. Events are dropped while the channel is full so that a
+// slow consumer can't hold up the shards.
+func (m *Manager) LifecycleEvents(size int) (<-chan LifecycleEvent, func()) {
+	c := make(chan LifecycleEvent, size)
+
+	m.lifecycleMu.Lock()
+	m.lifecycleSubs[c] = struct{}{}
+	m.lifecycleMu.Unlock()
+
+	return c, func() {
+		m.lifecycleMu.Lock()
+		defer m.lifecycleMu.Unlock()
+
+		if _, ok := m.lifecycleSubs[c]; ok {
+			delete(m.lifecycleSubs, c)
+			close(c)
+		}
+	}
+}
+
+// lifecycle passes a shard's lifecycle event to OnLifecycle and every channel
+func (m *Manager) lifecycle(e LifecycleEvent) {
+	if m.opts.OnLifecycle != nil {
+		m.opts.OnLifecycle(e)
+	}
+
+	m.lifecycleMu.RLock()
+	defer m.lifecycleMu.RUnlock()
+
+	for c := range m.lifecycleSubs {
+		select {
+		case c <- e:
+		default:
+			stats.LifecycleEventsDropped.Inc()
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package gateway
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"strings"
+	"sync/atomic"
 )
 
 // Usable log levels
@@ -15,34 +18,119 @@ const (
 	LogLevelDebug
 )
 
+// LogLevels maps log level names to their values
+var LogLevels = map[string]int{
+	"suppress": LogLevelSuppress,
+	"error":    LogLevelError,
+	"warn":     LogLevelWarn,
+	"info":     LogLevelInfo,
+	"debug":    LogLevelDebug,
+}
+
 // DefaultLogger is the default logger from which each child logger is derived
 var DefaultLogger = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
 
+// Redacted replaces sensitive values in log output
+const Redacted = "[REDACTED]"
+
+// DefaultRedactPatterns match sensitive values that are always removed from log output. If a
+// pattern contains a capture group, only the first group is redacted; otherwise the entire match
+// is.
+var DefaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[\w-]{23,28}\.[\w-]{6,7}\.[\w-]{27,}`),
+	regexp.MustCompile(`"(?:token|session_id|resume_gateway_url)":\s*"([^"]*)"`),
+}
+
 // ChildLogger creates a child logger with the specified prefix
 func ChildLogger(parent *log.Logger, prefix string) *log.Logger {
 	return log.New(parent.Writer(), parent.Prefix()+prefix+" ", parent.Flags())
 }
 
+// redact removes the given secrets and anything matching the given patterns from msg
+func redact(msg string, patterns []*regexp.Regexp, secrets ...string) string {
+	for _, pattern := range patterns {
+		if pattern.NumSubexp() == 0 {
+			msg = pattern.ReplaceAllLiteralString(msg, Redacted)
+			continue
+		}
+
+		var (
+			b    strings.Builder
+			last int
+		)
+		for _, loc := range pattern.FindAllStringSubmatchIndex(msg, -1) {
+			if loc[2] < 0 {
+				continue
+			}
+
+			b.WriteString(msg[last:loc[2]])
+			b.WriteString(Redacted)
+			last = loc[3]
+		}
+		b.WriteString(msg[last:])
+		msg = b.String()
+	}
+
+	for _, secret := range secrets {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, Redacted)
+		}
+	}
+
+	return msg
+}
+
+// LogLevel returns the shard's current log level
+func (s *Shard) LogLevel() int {
+	return int(atomic.LoadInt32(&s.logLevel))
+}
+
+// SetLogLevel changes the shard's log level
+func (s *Shard) SetLogLevel(level int) {
+	atomic.StoreInt32(&s.logLevel, int32(level))
+}
+
 func (s *Shard) log(level int, format string, args ...interface{}) {
-	if level > s.opts.LogLevel {
+	if level > s.LogLevel() {
 		return
 	}
 
-	s.opts.Logger.Printf(format+"\n", args...)
+	msg := redact(fmt.Sprintf(format, args...), s.opts.RedactPatterns, s.opts.Identify.Token, s.SessionID(), s.ResumeURL())
+	s.opts.Logger.Println(msg)
 }
 
 func (s *Shard) logTrace(trace []string) {
-	if LogLevelDebug > s.opts.LogLevel {
+	if LogLevelDebug > s.LogLevel() {
 		return
 	}
 
 	s.opts.Logger.Printf("Trace: %s\n", strings.Join(trace, " -> "))
 }
 
+// LogLevel returns the manager's current log level
+func (m *Manager) LogLevel() int {
+	return int(atomic.LoadInt32(&m.logLevel))
+}
+
+// SetLogLevel changes the log level of the manager and every shard, including those spawned later
+func (m *Manager) SetLogLevel(level int) {
+	atomic.StoreInt32(&m.logLevel, int32(level))
+
+	m.shardsMu.RLock()
+	defer m.shardsMu.RUnlock()
+	for _, s := range m.Shards {
+		s.SetLogLevel(level)
+	}
+}
+
 func (s *Manager) log(level int, format string, args ...interface{}) {
-	if level > s.opts.LogLevel {
+	if level > s.LogLevel() {
 		return
 	}
 
-	s.opts.Logger.Printf(format+"\n", args...)
+	msg := redact(fmt.Sprintf(format, args...), DefaultRedactPatterns)
+	if s.opts.ShardOptions != nil {
+		msg = redact(msg, s.opts.ShardOptions.RedactPatterns)
+	}
+	s.opts.Logger.Println(msg)
 }
@@ -3,8 +3,11 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/spec-tacles/gateway/stats"
 	"github.com/spec-tacles/go/broker"
@@ -23,20 +26,67 @@ type Manager struct {
 	Gateway     *types.GatewayBot
 	opts        *ManagerOptions
 	gatewayLock sync.Mutex
+
+	shardsMu sync.RWMutex
+	closing  bool
+	logLevel int32
+
+	// ctx is the context passed to Start while it's running; running contains the IDs of running
+	// shards, and stopped is signalled whenever one stops
+	ctx     context.Context
+	running map[int]bool
+	stopped *sync.Cond
+	groups  map[string]*ShardGroup
+	// fatal is the first error which stopped a shard while Start was running
+	fatal error
+
+	// readied contains the IDs of shards which have been ready since startedAt
+	progressMu sync.Mutex
+	startedAt  time.Time
+	readied    map[int]struct{}
+
+	// guilds maps the IDs of guilds to the shards serving them
+	guildsMu sync.RWMutex
+	guilds   map[uint64]int
+
+	lifecycleMu   sync.RWMutex
+	lifecycleSubs map[chan LifecycleEvent]struct{}
+
+	eventsMu sync.RWMutex
+	events   map[string]struct{}
+
+	brokerMu sync.RWMutex
+	broker   broker.Broker
 }
 
 // NewManager creates a new Gateway manager
 func NewManager(opts *ManagerOptions) *Manager {
 	opts.init()
 
-	return &Manager{
+	m := &Manager{
 		Shards:      make(map[int]*Shard),
 		opts:        opts,
 		gatewayLock: sync.Mutex{},
+		logLevel:    int32(opts.LogLevel),
+		running:     make(map[int]bool),
+		readied:     make(map[int]struct{}),
+		guilds:      make(map[uint64]int),
+
+		lifecycleSubs: make(map[chan LifecycleEvent]struct{}),
+	}
+	m.stopped = sync.NewCond(&m.shardsMu)
+	m.groups = m.newGroups()
+
+	if len(opts.Labels) > 0 {
+		if err := stats.SetLabels(opts.Labels); err != nil {
+			m.log(LogLevelError, "Unable to apply labels to metrics: %s", err)
+		}
 	}
+	return m
 }
 
-// Start starts all shards
+// Start starts all shards and returns once they've all stopped. Returns the first error which
+// stopped a shard, if any, unless the manager was closed or the context cancelled.
 func (m *Manager) Start(ctx context.Context) (err error) {
 	if m.opts.ShardCount == 0 {
 		m.log(LogLevelDebug, "Shard count unspecified: using Discord recommended value")
@@ -44,7 +94,7 @@ func (m *Manager) Start(ctx context.Context) (err error) {
 		var g *types.GatewayBot
 		g, err = m.FetchGateway()
 		if err != nil {
-			m.log(LogLevelError, "Failed to fetch gateway info", err)
+			m.log(LogLevelError, "Failed to fetch gateway info: %s", err)
 			return
 		}
 
@@ -56,31 +106,89 @@ func (m *Manager) Start(ctx context.Context) (err error) {
 		expected++
 	}
 
+	if m.opts.Metrics != nil {
+		metricsCtx, stopMetrics := context.WithCancel(ctx)
+		metricsDone := make(chan struct{})
+		go m.serveMetrics(metricsCtx, metricsDone)
+		defer func() {
+			stopMetrics()
+			<-metricsDone
+		}()
+	}
+
 	m.log(LogLevelInfo, "Starting %d shard(s) out of %d total", expected, m.opts.ShardCount)
 
-	wg := sync.WaitGroup{}
-	for i := m.opts.ServerIndex; i < m.opts.ShardCount; i += m.opts.ServerCount {
-		id := i
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	m.progressMu.Lock()
+	m.startedAt = time.Now()
+	m.progressMu.Unlock()
 
-			stats.TotalShards.Add(1)
-			defer stats.TotalShards.Sub(1)
+	m.shardsMu.Lock()
+	m.ctx = ctx
+	m.shardsMu.Unlock()
 
-			err := m.Spawn(ctx, id)
-			if err != nil {
-				m.log(LogLevelError, "Fatal error in shard %d: %s", id, err)
-			} else {
-				m.log(LogLevelDebug, "Shard %d closing gracefully", id)
-			}
-		}()
+	for id := m.opts.ServerIndex; id < m.opts.ShardCount; id += m.opts.ServerCount {
+		m.run(ctx, id)
 	}
 
-	wg.Wait()
+	// shards may be restarted (see ShardGroup.Start) until every shard has stopped
+	m.shardsMu.Lock()
+	for len(m.running) > 0 {
+		m.stopped.Wait()
+	}
+	m.ctx = nil
+	err, m.fatal = m.fatal, nil
+	m.shardsMu.Unlock()
 	return
 }
 
+// run spawns the shard in the background, unless it's already running
+func (m *Manager) run(ctx context.Context, id int) {
+	m.shardsMu.Lock()
+	if m.running[id] {
+		m.shardsMu.Unlock()
+		return
+	}
+	m.running[id] = true
+	m.shardsMu.Unlock()
+
+	go func() {
+		defer func() {
+			m.forgetGuilds(id)
+
+			m.shardsMu.Lock()
+			delete(m.running, id)
+			m.stopped.Broadcast()
+			m.shardsMu.Unlock()
+		}()
+
+		stats.TotalShards.Add(1)
+		defer stats.TotalShards.Sub(1)
+
+		err := m.Spawn(ctx, id)
+		if err != nil {
+			m.log(LogLevelError, "Fatal error in shard %d: %s", id, err)
+
+			m.shardsMu.Lock()
+			if m.fatal == nil && ctx.Err() == nil && !m.closing {
+				m.fatal = fmt.Errorf("shard %d: %w", id, err)
+			}
+			m.shardsMu.Unlock()
+		} else {
+			m.log(LogLevelDebug, "Shard %d closing gracefully", id)
+		}
+	}()
+}
+
+// serveMetrics serves Prometheus metrics until the context is cancelled
+func (m *Manager) serveMetrics(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	m.log(LogLevelInfo, "Exposing Prometheus metrics at %s%s", m.opts.Metrics.Address, m.opts.Metrics.Path)
+	if err := stats.Serve(ctx, *m.opts.Metrics); err != nil {
+		m.log(LogLevelError, "Metrics listener failed: %s", err)
+	}
+}
+
 // Spawn a new shard with the specified ID
 func (m *Manager) Spawn(ctx context.Context, id int) (err error) {
 	g, err := m.FetchGateway()
@@ -90,10 +198,26 @@ func (m *Manager) Spawn(ctx context.Context, id int) (err error) {
 
 	opts := m.opts.ShardOptions.clone()
 	opts.Identify.Shard = []int{id, m.opts.ShardCount}
-	opts.LogLevel = m.opts.LogLevel
+	opts.LogLevel = m.LogLevel()
 	opts.IdentifyLimiter = m.opts.ShardLimiter
+	opts.onPhase = func(phase ShardPhase) {
+		m.shardPhaseChanged(id, phase)
+	}
+	opts.onGuild = func(guildID uint64, joined bool) {
+		m.guildChanged(id, guildID, joined)
+	}
+	if onLifecycle := opts.OnLifecycle; onLifecycle != nil {
+		opts.OnLifecycle = func(e LifecycleEvent) {
+			onLifecycle(e)
+			m.lifecycle(e)
+		}
+	} else {
+		opts.OnLifecycle = m.lifecycle
+	}
 	if opts.Logger == nil {
 		opts.Logger = m.opts.Logger
+	} else if len(m.opts.Labels) > 0 {
+		opts.Logger = ChildLogger(opts.Logger, formatLabels(m.opts.Labels))
 	}
 
 	if m.opts.OnPacket != nil {
@@ -102,18 +226,95 @@ func (m *Manager) Spawn(ctx context.Context, id int) (err error) {
 		}
 	}
 
+	if m.opts.OnPacketContext != nil {
+		opts.OnPacketContext = m.opts.OnPacketContext
+	}
+
+	if len(m.opts.Values) > 0 {
+		values := make(map[interface{}]interface{}, len(m.opts.Values)+len(opts.Values))
+		for key, value := range m.opts.Values {
+			values[key] = value
+		}
+		for key, value := range opts.Values {
+			values[key] = value
+		}
+		opts.Values = values
+	}
+
+	if m.opts.ShardTags != nil {
+		opts.Tags = m.opts.ShardTags(id)
+	}
+	if g := m.groupOf(id); g != nil {
+		opts.Tags = g.tags(opts.Tags)
+	}
+
+	if m.isCanary(id) {
+		opts.Canary = true
+		if m.opts.CanaryOptions != nil {
+			m.opts.CanaryOptions(opts)
+		}
+		m.log(LogLevelInfo, "Shard %d is running as a canary", id)
+	}
+
 	s := NewShard(opts)
 	s.Gateway = g
+
+	m.shardsMu.Lock()
+	if m.closing {
+		m.shardsMu.Unlock()
+		return ErrShardClosing
+	}
 	m.Shards[id] = s
+	m.shardsMu.Unlock()
 
 	err = s.Open(ctx)
-	if err != nil {
+	if err != nil || s.isClosing() {
 		return
 	}
 
 	return s.Close()
 }
 
+// Close closes every shard resumably, so that their sessions can be resumed after a restart, and
+// stops handling packets from the broker. Start returns once every shard has closed.
+func (m *Manager) Close() {
+	m.shardsMu.Lock()
+	defer m.shardsMu.Unlock()
+
+	m.closing = true
+	for id, s := range m.Shards {
+		if err := s.CloseResumable(); err != nil {
+			m.log(LogLevelWarn, "Error closing shard %d: %s", id, err)
+		}
+	}
+}
+
+// Shard returns the shard with the given ID, or nil if it hasn't been spawned
+func (m *Manager) Shard(id int) *Shard {
+	m.shardsMu.RLock()
+	defer m.shardsMu.RUnlock()
+
+	return m.Shards[id]
+}
+
+// isClosing returns whether the manager has been closed
+func (m *Manager) isClosing() bool {
+	m.shardsMu.RLock()
+	defer m.shardsMu.RUnlock()
+
+	return m.closing
+}
+
+// isCanary returns whether the shard with the given ID is a canary
+func (m *Manager) isCanary(id int) bool {
+	for _, canary := range m.opts.CanaryShards {
+		if canary == id {
+			return true
+		}
+	}
+	return false
+}
+
 // FetchGateway fetches the gateway or from cache
 func (m *Manager) FetchGateway() (g *types.GatewayBot, err error) {
 	m.gatewayLock.Lock()
@@ -137,16 +338,22 @@ func (m *Manager) ConnectBroker(ctx context.Context, b broker.Broker, events map
 		return
 	}
 
+	m.setEvents(events)
+	m.brokerMu.Lock()
+	m.broker = b
+	m.brokerMu.Unlock()
+
 	m.opts.OnPacket = func(shard int, d *types.ReceivePacket) {
-		if d.Op != types.GatewayOpDispatch {
+		if d.Op != types.GatewayOpDispatch || !m.publishes(d.Event) {
 			return
 		}
 
-		if _, ok := events[string(d.Event)]; !ok {
-			return
+		var data interface{} = d.Data
+		if m.opts.Envelope {
+			data = m.envelope(shard, d)
 		}
 
-		err := b.Publish(ctx, string(d.Event), d.Data)
+		err := b.Publish(ctx, string(d.Event), data)
 		if err != nil {
 			m.log(LogLevelError, "failed to publish packet to broker: %s", err)
 		}
@@ -167,7 +374,50 @@ func (m *Manager) ConnectBroker(ctx context.Context, b broker.Broker, events map
 	go b.Subscribe(ctx, eventList, ch)
 }
 
+// Events returns the names of the events published to the broker, sorted by name
+func (m *Manager) Events() []string {
+	m.eventsMu.RLock()
+	defer m.eventsMu.RUnlock()
+
+	events := make([]string, 0, len(m.events))
+	for event := range m.events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// SetEvents changes which events are published to the broker
+func (m *Manager) SetEvents(events []string) {
+	set := make(map[string]struct{}, len(events))
+	for _, event := range events {
+		set[event] = struct{}{}
+	}
+	m.setEvents(set)
+}
+
+func (m *Manager) setEvents(events map[string]struct{}) {
+	m.eventsMu.Lock()
+	defer m.eventsMu.Unlock()
+
+	m.events = events
+}
+
+// publishes returns whether the event is published to the broker
+func (m *Manager) publishes(event types.GatewayEvent) bool {
+	m.eventsMu.RLock()
+	defer m.eventsMu.RUnlock()
+
+	_, ok := m.events[string(event)]
+	return ok
+}
+
 func (m *Manager) handleMessage(ctx context.Context, b broker.Broker, msg broker.Message) {
+	if m.isClosing() {
+		m.log(LogLevelDebug, "ignoring %s message from broker: shutting down", msg.Event())
+		return
+	}
+
 	var (
 		shard  *Shard
 		packet *types.SendPacket
@@ -188,7 +438,7 @@ func (m *Manager) handleMessage(ctx context.Context, b broker.Broker, msg broker
 		}
 
 		shardID := int(p.GuildID >> 22 % uint64(m.opts.ShardCount))
-		shard = m.Shards[shardID]
+		shard = m.Shard(shardID)
 		if shard == nil {
 			data, err := json.Marshal(p.Packet)
 			if err != nil {
@@ -208,7 +458,7 @@ func (m *Manager) handleMessage(ctx context.Context, b broker.Broker, msg broker
 		if err != nil {
 			m.log(LogLevelWarn, "received unexpected non-int event from AMQP: %s", err)
 		}
-		shard = m.Shards[shardID]
+		shard = m.Shard(shardID)
 		if shard == nil {
 			m.log(LogLevelWarn, "received event for shard %d which does not exist", shardID)
 			return
@@ -1,12 +1,20 @@
 package gateway
 
 import (
+	"context"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/spec-tacles/gateway/stats"
 	"github.com/spec-tacles/go/types"
 )
 
+// DefaultIdentifyInterval is the default interval between identifies. This is supposed to be 5s,
+// but 5s causes every other session to be invalidated.
+const DefaultIdentifyInterval = 5250 * time.Millisecond
+
 // ShardLimiter controls the rate at which the manager creates and starts shards
 type ShardLimiter interface {
 	Wait(int) error
@@ -24,14 +32,45 @@ type ManagerOptions struct {
 
 	OnPacket func(int, *types.ReceivePacket)
 
+	// OnPacketContext and Values are passed to every shard; Values set in ShardOptions take
+	// precedence over these
+	OnPacketContext func(context.Context, *types.ReceivePacket)
+	Values          map[interface{}]interface{}
+
+	// OnProgress is called with the startup progress whenever a shard's startup phase changes
+	OnProgress func(StartupProgress)
+
+	// OnLifecycle is called with the lifecycle events of every shard (see also
+	// Manager.LifecycleEvents), after the shard's own OnLifecycle
+	OnLifecycle func(LifecycleEvent)
+
+	// ShardTags returns the tags to attach to the shard with the given ID
+	ShardTags func(int) map[string]string
+	// Envelope publishes dispatches to the broker wrapped in an Envelope instead of as raw data
+	Envelope bool
+
+	// Groups divide the shards into units which can be started, stopped, and drained together
+	Groups []ShardGroupOptions
+
+	// CanaryShards are run with CanaryOptions applied to their shard options, and report metrics
+	// under the canary cohort so they can be compared against the rest of the shards
+	CanaryShards  []int
+	CanaryOptions func(*ShardOptions)
+
 	Logger   *log.Logger
 	LogLevel int
+
+	// Metrics starts an HTTP listener serving Prometheus metrics while the manager is running
+	Metrics *stats.ServerOptions
+
+	// Labels (e.g. cluster name, replica ID, or environment) are attached to every metric and
+	// prefixed to every log line
+	Labels map[string]string
 }
 
 func (opts *ManagerOptions) init() {
 	if opts.ShardLimiter == nil {
-		// this is supposed to be 5s, but 5s causes every other session to be invalidated
-		opts.ShardLimiter = NewDefaultLimiter(1, 5250*time.Millisecond)
+		opts.ShardLimiter = NewDefaultLimiter(1, DefaultIdentifyInterval)
 	}
 
 	if opts.ServerCount == 0 {
@@ -41,5 +80,19 @@ func (opts *ManagerOptions) init() {
 	if opts.Logger == nil {
 		opts.Logger = DefaultLogger
 	}
+	if len(opts.Labels) > 0 {
+		opts.Logger = ChildLogger(opts.Logger, formatLabels(opts.Labels))
+	}
 	opts.Logger = ChildLogger(opts.Logger, "[manager]")
 }
+
+// formatLabels formats labels as a log prefix, sorted by name
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return "[" + strings.Join(pairs, " ") + "]"
+}
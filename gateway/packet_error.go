@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// PacketErrorPolicy determines how a shard handles a packet that can't be decoded
+type PacketErrorPolicy int
+
+// Packet error policies
+const (
+	// PacketErrorSkip drops the packet and continues reading from the connection
+	PacketErrorSkip PacketErrorPolicy = iota
+	// PacketErrorReconnect ends the connection
+	PacketErrorReconnect
+)
+
+// PacketError represents a failure to decode a single packet, which leaves the connection itself
+// intact
+type PacketError struct {
+	Reason string
+	Err    error
+}
+
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("bad packet (%s): %s", e.Reason, e.Err)
+}
+
+func (e *PacketError) Unwrap() error {
+	return e.Err
+}
+
+// skipPacketError returns whether the read loop should continue after the given error, recording
+// the dropped packet if so
+func (s *Shard) skipPacketError(err error) bool {
+	var pe *PacketError
+	if s.opts.PacketErrorPolicy != PacketErrorSkip || !errors.As(err, &pe) {
+		return false
+	}
+
+	stats.PacketsDropped.WithLabelValues(pe.Reason, s.id).Inc()
+	s.log(LogLevelWarn, "dropping packet: %s", pe)
+	return true
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// receivedPacket is a packet buffered along with the time it was received
+type receivedPacket struct {
+	packet     *types.ReceivePacket
+	receivedAt time.Time
+}
+
+// Pause stops passing dispatches to OnPacket and OnPacketContext without affecting the connection,
+// so heartbeats and the session continue as normal. Dispatches received while paused are buffered,
+// up to ShardOptions.PauseBufferSize, and the rest are dropped.
+func (s *Shard) Pause() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if !s.paused || s.replaying {
+		s.paused = true
+		s.replaying = false
+		s.log(LogLevelInfo, "Paused event consumption")
+	}
+}
+
+// Resume passes any dispatches buffered while paused to the handlers and resumes normal
+// consumption. Handlers are called without any locks held, so they may pause or resume the shard
+// themselves.
+func (s *Shard) Resume() {
+	s.pauseMu.Lock()
+	if !s.paused || s.replaying {
+		s.pauseMu.Unlock()
+		return
+	}
+
+	s.replaying = true
+	s.log(LogLevelInfo, "Resuming event consumption with %d buffered dispatch(es)", len(s.pauseBuffer))
+	s.pauseMu.Unlock()
+
+	for {
+		s.pauseMu.Lock()
+		if !s.replaying {
+			// paused again by a handler
+			s.pauseMu.Unlock()
+			return
+		}
+
+		if len(s.pauseBuffer) == 0 {
+			s.pauseBuffer = nil
+			s.paused = false
+			s.replaying = false
+			s.pauseMu.Unlock()
+			return
+		}
+
+		p := s.pauseBuffer[0]
+		s.pauseBuffer = s.pauseBuffer[1:]
+		s.pauseMu.Unlock()
+
+		s.handle(p.packet, p.receivedAt)
+	}
+}
+
+// Paused returns whether event consumption is paused
+func (s *Shard) Paused() bool {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	return s.paused
+}
+
+// deliver passes the packet to the handlers, or buffers it if it's a dispatch and the shard is
+// paused
+func (s *Shard) deliver(p *types.ReceivePacket, receivedAt time.Time) {
+	if !s.hasHandlers() || !s.sampled(p) {
+		return
+	}
+
+	// handlers are called without holding pauseMu so that they can pause or resume the shard
+	s.pauseMu.Lock()
+	if !s.paused || p.Op != types.GatewayOpDispatch {
+		s.pauseMu.Unlock()
+		s.handle(p, receivedAt)
+		return
+	}
+	defer s.pauseMu.Unlock()
+
+	if len(s.pauseBuffer) >= s.opts.PauseBufferSize {
+		stats.PausedDispatches.WithLabelValues("dropped", s.id).Inc()
+		return
+	}
+
+	// packets are pooled, so the buffered packet must be a copy
+	buffered := *p
+	buffered.Data = append([]byte(nil), p.Data...)
+	s.pauseBuffer = append(s.pauseBuffer, receivedPacket{&buffered, receivedAt})
+	stats.PausedDispatches.WithLabelValues("buffered", s.id).Inc()
+}
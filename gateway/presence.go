@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/go/types"
+)
+
+// UpdatePresence queues a presence update, subject to the presence ratelimit. Updates made while
+// waiting on the ratelimit are coalesced so that only the most recent one is sent. Updates to
+// read-only shards are discarded.
+func (s *Shard) UpdatePresence(presence *types.StatusUpdate) {
+	if s.refuseSend(types.GatewayOpStatusUpdate) != nil {
+		return
+	}
+	s.queuePresence(presence)
+}
+
+// queuePresence stores the presence data as pending and starts a flush if one isn't already waiting
+func (s *Shard) queuePresence(data interface{}) {
+	s.presenceMu.Lock()
+	defer s.presenceMu.Unlock()
+
+	s.pendingPresence = data
+	s.lastPresence = data
+	if s.presenceQueued {
+		s.log(LogLevelDebug, "coalescing presence update with pending update")
+		return
+	}
+
+	s.presenceQueued = true
+	go s.flushPresence()
+}
+
+// resyncPresence re-sends the presence after a new session, since Discord doesn't retain it. The
+// presence is computed by ShardOptions.Presence if set, otherwise the last presence set is used.
+func (s *Shard) resyncPresence() {
+	if s.opts.ReadOnly {
+		return
+	}
+
+	var data interface{}
+	if s.opts.Presence != nil {
+		if presence := s.opts.Presence(s); presence != nil {
+			data = presence
+		}
+	}
+
+	if data == nil {
+		s.presenceMu.Lock()
+		data = s.lastPresence
+		s.presenceMu.Unlock()
+	}
+
+	if data != nil {
+		s.log(LogLevelDebug, "resynchronizing presence")
+		s.queuePresence(data)
+	}
+}
+
+// flushPresence waits for the presence ratelimit and then sends the latest pending presence
+func (s *Shard) flushPresence() {
+	s.presenceLimiter.Lock()
+
+	s.presenceMu.Lock()
+	data := s.pendingPresence
+	s.pendingPresence = nil
+	s.presenceQueued = false
+	s.presenceMu.Unlock()
+
+	err := s.send(&types.SendPacket{
+		Op:   types.GatewayOpStatusUpdate,
+		Data: data,
+	})
+	if err != nil {
+		s.log(LogLevelError, "error sending presence update: %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// ShardPhase is a stage of a shard's startup
+type ShardPhase string
+
+// Shard phases
+const (
+	// ShardPending shards haven't been spawned yet
+	ShardPending ShardPhase = "pending"
+	// ShardConnecting shards are connecting, or waiting to reconnect
+	ShardConnecting ShardPhase = "connecting"
+	// ShardIdentifying shards are waiting to identify or resume, or for their session to start
+	ShardIdentifying ShardPhase = "identifying"
+	// ShardReady shards have a session
+	ShardReady ShardPhase = "ready"
+)
+
+// StartupProgress represents the progress of the manager's shards towards being ready
+type StartupProgress struct {
+	Total       int `json:"total"`
+	Pending     int `json:"pending"`
+	Connecting  int `json:"connecting"`
+	Identifying int `json:"identifying"`
+	Ready       int `json:"ready"`
+
+	// Remaining estimates the time until every shard has been ready, based on the pace at which
+	// shards have become ready so far (or the default identify interval, before any have)
+	Remaining time.Duration `json:"remaining"`
+	Done      bool          `json:"done"`
+}
+
+// Phase returns the shard's current startup phase
+func (s *Shard) Phase() ShardPhase {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	if s.phase == "" {
+		return ShardPending
+	}
+	return s.phase
+}
+
+// setPhase changes the shard's startup phase
+func (s *Shard) setPhase(phase ShardPhase) {
+	s.stateMu.Lock()
+	changed := s.phase != phase
+	s.phase = phase
+	s.stateMu.Unlock()
+
+	if changed && s.opts.onPhase != nil {
+		s.opts.onPhase(phase)
+	}
+}
+
+// Progress returns the startup progress of the shards run by the manager
+func (m *Manager) Progress() (p StartupProgress) {
+	m.shardsMu.RLock()
+	for id := m.opts.ServerIndex; id < m.opts.ShardCount; id += m.opts.ServerCount {
+		p.Total++
+
+		phase := ShardPending
+		if s := m.Shards[id]; s != nil {
+			phase = s.Phase()
+		}
+
+		switch phase {
+		case ShardPending:
+			p.Pending++
+		case ShardConnecting:
+			p.Connecting++
+		case ShardIdentifying:
+			p.Identifying++
+		case ShardReady:
+			p.Ready++
+		}
+	}
+	m.shardsMu.RUnlock()
+
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+
+	remaining := p.Total - len(m.readied)
+	p.Done = p.Total > 0 && remaining <= 0
+	if remaining > 0 {
+		pace := DefaultIdentifyInterval
+		if len(m.readied) > 0 {
+			pace = time.Since(m.startedAt) / time.Duration(len(m.readied))
+		}
+		p.Remaining = time.Duration(remaining) * pace
+	}
+	return
+}
+
+// shardPhaseChanged records the shard's new phase and reports the manager's progress
+func (m *Manager) shardPhaseChanged(id int, phase ShardPhase) {
+	if phase == ShardReady {
+		m.progressMu.Lock()
+		m.readied[id] = struct{}{}
+		m.progressMu.Unlock()
+	}
+
+	p := m.Progress()
+	stats.StartupShards.WithLabelValues(string(ShardPending)).Set(float64(p.Pending))
+	stats.StartupShards.WithLabelValues(string(ShardConnecting)).Set(float64(p.Connecting))
+	stats.StartupShards.WithLabelValues(string(ShardIdentifying)).Set(float64(p.Identifying))
+	stats.StartupShards.WithLabelValues(string(ShardReady)).Set(float64(p.Ready))
+	stats.StartupRemaining.Set(p.Remaining.Seconds())
+
+	if m.opts.OnProgress != nil {
+		m.opts.OnProgress(p)
+	}
+}
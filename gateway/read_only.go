@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"strconv"
+
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// essential returns whether packets with the operation are required to maintain a session
+func essential(op types.GatewayOp) bool {
+	switch op {
+	case types.GatewayOpHeartbeat, types.GatewayOpIdentify, types.GatewayOpResume:
+		return true
+	}
+	return false
+}
+
+// refuseSend returns ErrReadOnly if the shard is read-only and the operation isn't essential
+func (s *Shard) refuseSend(op types.GatewayOp) error {
+	if !s.opts.ReadOnly || essential(op) {
+		return nil
+	}
+
+	stats.ReadOnlyRefused.WithLabelValues(strconv.Itoa(int(op)), s.id).Inc()
+	s.log(LogLevelWarn, "refusing to send op %d: shard is read-only", op)
+	return ErrReadOnly
+}
+
+// identifyPayload returns the identify to send, without a presence if the shard is read-only
+func (s *Shard) identifyPayload() *types.Identify {
+	if !s.opts.ReadOnly || s.opts.Identify.Presence == nil {
+		return s.opts.Identify
+	}
+
+	identify := *s.opts.Identify
+	identify.Presence = nil
+	return &identify
+}
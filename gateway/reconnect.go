@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// ReconnectOutcome represents the result of a reconnect attempt
+type ReconnectOutcome string
+
+// Reconnect outcomes
+const (
+	ReconnectSucceeded ReconnectOutcome = "succeeded"
+	ReconnectFailed    ReconnectOutcome = "failed"
+)
+
+// ReconnectAttempt describes a single attempt to reconnect after a connection ended
+type ReconnectAttempt struct {
+	ShardID int
+	// Attempt is the number of consecutive attempts made since the last established session
+	Attempt int
+	// Backoff is how long the shard waited before this attempt
+	Backoff   time.Duration
+	Resumable bool
+	Outcome   ReconnectOutcome
+	// Err is the error that ended the attempt, if it failed
+	Err error
+}
+
+// reconnect waits according to the retryer and then starts a new connection. attempt is the number
+// of consecutive attempts, including this one, and backoff is the wait before the previous attempt.
+func (s *Shard) reconnect(ctx context.Context, attempt int, backoff time.Duration) (next time.Duration, err error) {
+	switch attempt {
+	case 1:
+		// the previous connection was healthy, so reconnect right away
+	case 2:
+		next = s.opts.Retryer.FirstTimeout()
+	default:
+		if next, err = s.opts.Retryer.NextTimeout(backoff, attempt-1); err != nil {
+			return
+		}
+	}
+
+	a := &ReconnectAttempt{
+		ShardID:   s.opts.Identify.Shard[0],
+		Attempt:   attempt,
+		Backoff:   next,
+		Resumable: s.SessionID() != "",
+	}
+
+	stats.ReconnectStreak.WithLabelValues(s.id).Set(float64(attempt))
+	stats.ReconnectBackoff.WithLabelValues(s.id).Observe(next.Seconds())
+	s.log(LogLevelInfo, "reconnect attempt %d in %s (resumable: %t)", attempt, next, a.Resumable)
+
+	c, stop := s.after(next)
+	defer stop()
+
+	select {
+	case <-c:
+	case <-ctx.Done():
+		return next, ctx.Err()
+	}
+
+	s.stateMu.Lock()
+	s.attempt = a
+	s.stateMu.Unlock()
+
+	err = s.connect(ctx)
+	s.finishAttempt(err)
+	return
+}
+
+// finishAttempt records the outcome of the pending reconnect attempt, if any. A nil error
+// indicates the attempt established a session.
+func (s *Shard) finishAttempt(err error) {
+	s.stateMu.Lock()
+	a := s.attempt
+	s.attempt = nil
+	s.stateMu.Unlock()
+
+	if a == nil {
+		return
+	}
+
+	a.Outcome = ReconnectSucceeded
+	if err != nil {
+		a.Outcome = ReconnectFailed
+		a.Err = err
+	} else {
+		stats.ReconnectStreak.WithLabelValues(s.id).Set(0)
+	}
+
+	stats.ReconnectAttempts.WithLabelValues(string(a.Outcome), strconv.FormatBool(a.Resumable), s.id).Inc()
+	if s.opts.OnReconnect != nil {
+		s.opts.OnReconnect(*a)
+	}
+}
+
+// markEstablished records that the current connection established a session
+func (s *Shard) markEstablished() {
+	s.stateMu.Lock()
+	s.established = true
+	s.started = true
+	s.invalidSessions = 0
+	s.stateMu.Unlock()
+
+	s.finishAttempt(nil)
+	s.setSendReady(true)
+	s.setPhase(ShardReady)
+}
+
+// takeEstablished returns whether a session was established since the last call
+func (s *Shard) takeEstablished() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	established := s.established
+	s.established = false
+	return established
+}
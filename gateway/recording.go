@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// RecordedEvent is a dispatch in a traffic recording
+type RecordedEvent struct {
+	Time    time.Time          `json:"time"`
+	ShardID int                `json:"shard_id"`
+	Event   types.GatewayEvent `json:"t"`
+	Data    json.RawMessage    `json:"d"`
+}
+
+// Recorder writes a traffic recording consisting of one RecordedEvent per line
+type Recorder struct {
+	mux sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record writes the event to the recording
+func (r *Recorder) Record(e *Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.enc.Encode(&RecordedEvent{
+		Time:    e.ReceivedAt,
+		ShardID: e.ShardID,
+		Event:   e.Packet.Event,
+		Data:    e.Packet.Data,
+	})
+}
+
+// Run records every event received by the subscription until it's closed
+func (r *Recorder) Run(sub *Subscription, onError func(error)) {
+	for e := range sub.C {
+		if err := r.Record(e); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Backfill publishes the recorded events received between from and to (inclusive) to the broker
+// connected with ConnectBroker, so that consumers can recover lost events. Backfilled events are
+// always wrapped in an Envelope with Backfill set. Events which aren't published are skipped.
+func (m *Manager) Backfill(ctx context.Context, recording io.Reader, from, to time.Time) (published int, err error) {
+	m.brokerMu.RLock()
+	b := m.broker
+	m.brokerMu.RUnlock()
+	if b == nil {
+		return 0, ErrNoBroker
+	}
+
+	scanner := bufio.NewScanner(recording)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for scanner.Scan() {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		e := new(RecordedEvent)
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return
+		}
+
+		if e.Time.Before(from) || e.Time.After(to) || !m.publishes(e.Event) {
+			continue
+		}
+
+		envelope := m.envelope(e.ShardID, &types.ReceivePacket{
+			Op:    types.GatewayOpDispatch,
+			Event: e.Event,
+			Data:  e.Data,
+		})
+		envelope.Backfill = true
+
+		if err = b.Publish(ctx, string(e.Event), envelope); err != nil {
+			return
+		}
+		published++
+	}
+
+	if err = scanner.Err(); err == nil {
+		m.log(LogLevelInfo, "Backfilled %d event(s) from %s to %s", published, from, to)
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// dialURL returns the URL to connect to, and whether it's the resume URL. The resume URL provided
+// in READY is used while there's a session to resume.
+func (s *Shard) dialURL() (url string, resuming bool) {
+	s.stateMu.RLock()
+	resumeURL := s.resumeURL
+	resuming = resumeURL != "" && s.sessionID != "" && !s.identifyNext
+	s.stateMu.RUnlock()
+
+	if !resuming {
+		return s.gatewayURL(), false
+	}
+	return s.formatURL(resumeURL), true
+}
+
+// resumeDialed records the outcome of dialing the resume URL. After too many consecutive failures
+// (e.g. DNS failures or refused connections after an infrastructure change), the resume URL is
+// cleared so that the session is resumed through the main gateway URL instead.
+func (s *Shard) resumeDialed(err error) {
+	s.stateMu.Lock()
+	if err == nil {
+		s.resumeDialFailures = 0
+		s.stateMu.Unlock()
+		return
+	}
+
+	s.resumeDialFailures++
+	failures := s.resumeDialFailures
+	fallback := s.opts.ResumeURLMaxFailures > 0 && failures >= s.opts.ResumeURLMaxFailures
+	if fallback {
+		s.resumeURL = ""
+		s.resumeDialFailures = 0
+	}
+	s.stateMu.Unlock()
+
+	// the resume URL is bound to the session, and is no longer redacted once it's been cleared
+	if !fallback {
+		s.log(LogLevelWarn, "Unable to dial resume URL (%d consecutive failures): %s", failures, err)
+		return
+	}
+
+	stats.ResumeURLFallbacks.WithLabelValues(s.id).Inc()
+	s.log(LogLevelWarn, "Falling back to the main gateway URL after %d failures to dial the resume URL: %s", failures, err)
+}
@@ -0,0 +1,26 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// sampled returns whether the dispatch should be passed to OnPacket according to the sample rate
+// for its event. Dispatches that are sampled out are still processed by the shard itself.
+func (s *Shard) sampled(p *types.ReceivePacket) bool {
+	if p.Op != types.GatewayOpDispatch {
+		return true
+	}
+
+	rate, ok := s.opts.SampleRates[p.Event]
+	if !ok || rate >= 1 {
+		return true
+	}
+
+	if rate > 0 && s.opts.Random.Float64() < rate {
+		return true
+	}
+
+	stats.SampledOut.WithLabelValues(string(p.Event), s.id).Inc()
+	return false
+}
@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/spec-tacles/go/types"
+)
+
+var errScanSyntax = errors.New("invalid JSON")
+
+// scanObject calls fn with the key and raw value of each member of the JSON object in data, in
+// order, until fn returns false. Nested values are skipped over without being decoded, which makes
+// this much cheaper than unmarshalling when only a few top-level fields are needed. Keys are
+// returned as they appear in the input, without unescaping.
+func scanObject(data []byte, fn func(key, value []byte) bool) error {
+	i := skipSpace(data, 0)
+	if i >= len(data) || data[i] != '{' {
+		return errScanSyntax
+	}
+
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return nil
+	}
+
+	for {
+		end, err := skipString(data, i)
+		if err != nil {
+			return err
+		}
+		key := data[i+1 : end-1]
+
+		i = skipSpace(data, end)
+		if i >= len(data) || data[i] != ':' {
+			return errScanSyntax
+		}
+
+		i = skipSpace(data, i+1)
+		if end, err = skipValue(data, i); err != nil {
+			return err
+		}
+
+		if !fn(key, data[i:end]) {
+			return nil
+		}
+
+		i = skipSpace(data, end)
+		if i >= len(data) {
+			return errScanSyntax
+		}
+
+		switch data[i] {
+		case ',':
+			i = skipSpace(data, i+1)
+		case '}':
+			return nil
+		default:
+			return errScanSyntax
+		}
+	}
+}
+
+// scanPacket decodes the packet's op, sequence, and event name without unmarshalling its data,
+// which is left referencing d. Falls back to unmarshalling for input it can't handle.
+func scanPacket(d []byte, p *types.ReceivePacket) error {
+	*p = types.ReceivePacket{}
+
+	var fallback bool
+	err := scanObject(d, func(key, value []byte) bool {
+		switch string(key) {
+		case "op":
+			op, err := strconv.Atoi(string(value))
+			p.Op = types.GatewayOp(op)
+			fallback = err != nil
+		case "s":
+			if string(value) != "null" {
+				seq, err := strconv.ParseUint(string(value), 10, 64)
+				p.Seq = types.Seq(seq)
+				fallback = err != nil
+			}
+		case "t":
+			if string(value) != "null" {
+				event := scanString(value)
+				p.Event = types.GatewayEvent(event)
+				fallback = event == nil || bytes.IndexByte(event, '\\') >= 0
+			}
+		case "d":
+			p.Data = value
+		}
+		return !fallback
+	})
+
+	if err != nil || fallback {
+		*p = types.ReceivePacket{}
+		return json.Unmarshal(d, p)
+	}
+	return nil
+}
+
+// scanString returns the contents of a raw JSON string value, or nil if the value isn't a string.
+// Escape sequences are not decoded.
+func scanString(value []byte) []byte {
+	if len(value) < 2 || value[0] != '"' {
+		return nil
+	}
+	return value[1 : len(value)-1]
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipString returns the index after the string starting at i
+func skipString(data []byte, i int) (int, error) {
+	if i >= len(data) || data[i] != '"' {
+		return 0, errScanSyntax
+	}
+
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, errScanSyntax
+}
+
+// skipValue returns the index after the value starting at i
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, errScanSyntax
+	}
+
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				end, err := skipString(data, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+			i++
+		}
+		return 0, errScanSyntax
+	}
+
+	start := i
+	for i < len(data) {
+		switch data[i] {
+		case ',', '}', ']', ' ', '\t', '\r', '\n':
+			if i == start {
+				return 0, errScanSyntax
+			}
+			return i, nil
+		}
+		i++
+	}
+
+	if i == start {
+		return 0, errScanSyntax
+	}
+	return i, nil
+}
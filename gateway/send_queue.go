@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// Default send queue limits
+const (
+	DefaultSendQueueSize   = 100
+	DefaultSendQueueExpiry = time.Minute
+)
+
+type queuedPacket struct {
+	packet   *types.SendPacket
+	queuedAt time.Time
+}
+
+// queueable returns whether packets with the given op are queued while the shard has no session.
+// Packets that establish or maintain the connection are always sent immediately.
+func queueable(op types.GatewayOp) bool {
+	return !essential(op)
+}
+
+// enqueue queues the packet if the shard has no session, returning whether it was queued
+func (s *Shard) enqueue(p *types.SendPacket) (queued bool, err error) {
+	if s.opts.SendQueueSize < 0 || !queueable(p.Op) {
+		return
+	}
+
+	s.sendQueueMu.Lock()
+	defer s.sendQueueMu.Unlock()
+
+	if s.sendReady {
+		return
+	}
+
+	if len(s.sendQueue) >= s.opts.SendQueueSize {
+		stats.SendQueue.WithLabelValues("overflow", s.id).Inc()
+		return false, ErrSendQueueFull
+	}
+
+	s.sendQueue = append(s.sendQueue, queuedPacket{p, s.opts.TimeSource.Now()})
+	stats.SendQueue.WithLabelValues("queued", s.id).Inc()
+	return true, nil
+}
+
+// setSendReady sets whether packets can be sent immediately. Once ready, any queued packets are
+// sent in order before new packets stop being queued.
+func (s *Shard) setSendReady(ready bool) {
+	s.sendQueueMu.Lock()
+	defer s.sendQueueMu.Unlock()
+
+	if !ready {
+		s.sendReady = false
+		s.sendGeneration++
+		return
+	}
+
+	go s.flushSendQueue(s.sendGeneration)
+}
+
+// flushSendQueue sends queued packets that haven't expired, then marks the shard as ready to send.
+// It stops without marking the shard as ready if the shard becomes unready in the meantime (the
+// generation changes), leaving the remaining packets queued for the next connection.
+func (s *Shard) flushSendQueue(generation uint64) {
+	for {
+		s.sendQueueMu.Lock()
+		if s.sendGeneration != generation {
+			s.sendQueueMu.Unlock()
+			return
+		}
+
+		if len(s.sendQueue) == 0 {
+			s.sendReady = true
+			s.sendQueueMu.Unlock()
+			return
+		}
+
+		q := s.sendQueue[0]
+		s.sendQueue = s.sendQueue[1:]
+		s.sendQueueMu.Unlock()
+
+		if s.opts.TimeSource.Now().Sub(q.queuedAt) > s.opts.SendQueueExpiry {
+			stats.SendQueue.WithLabelValues("expired", s.id).Inc()
+			continue
+		}
+
+		err := s.write(q.packet)
+		if err == nil {
+			stats.SendQueue.WithLabelValues("sent", s.id).Inc()
+			continue
+		}
+
+		s.sendQueueMu.Lock()
+		if err == ErrNotConnected || s.sendGeneration != generation {
+			s.sendQueue = append([]queuedPacket{q}, s.sendQueue...)
+			s.sendQueueMu.Unlock()
+			return
+		}
+		s.sendQueueMu.Unlock()
+
+		s.log(LogLevelError, "error sending queued packet (%d): %s", q.packet.Op, err)
+	}
+}
@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+)
+
+// persistSeq writes the sequence to the store once SeqPersistEvents dispatches have been received
+// since it was last written, or SeqPersistInterval after the first of them, whichever is sooner.
+// Without either, every sequence is written.
+func (s *Shard) persistSeq(ctx context.Context) error {
+	s.seqPersistMu.Lock()
+	s.unpersistedSeqs++
+
+	events, interval := s.opts.SeqPersistEvents, s.opts.SeqPersistInterval
+	due := (events <= 0 && interval <= 0) || (events > 0 && s.unpersistedSeqs >= events)
+	if !due {
+		// the timer writes the sequence even if no more dispatches are received
+		if interval > 0 && s.seqPersistTimer == nil {
+			s.seqPersistTimer = s.opts.TimeSource.AfterFunc(interval, s.flushSeq)
+		}
+		s.seqPersistMu.Unlock()
+		return nil
+	}
+
+	s.resetSeqPersist()
+	s.seqPersistMu.Unlock()
+
+	return s.writeSeq(ctx)
+}
+
+// flushSeq writes the current sequence to the store if any haven't been written
+func (s *Shard) flushSeq() {
+	s.seqPersistMu.Lock()
+	unpersisted := s.unpersistedSeqs
+	s.resetSeqPersist()
+	s.seqPersistMu.Unlock()
+
+	if unpersisted == 0 {
+		return
+	}
+
+	// the shard's context may already be done, but the sequence should still be written
+	if err := s.writeSeq(context.Background()); err != nil {
+		s.log(LogLevelWarn, "Unable to persist sequence: %s", err)
+	}
+}
+
+// resetSeqPersist marks every sequence as written; seqPersistMu must be held
+func (s *Shard) resetSeqPersist() {
+	s.unpersistedSeqs = 0
+	if s.seqPersistTimer != nil {
+		s.seqPersistTimer.Stop()
+		s.seqPersistTimer = nil
+	}
+}
+
+// writeSeq writes the current sequence to the store. Writes are serialized so that a slow write
+// can't overwrite a later sequence.
+func (s *Shard) writeSeq(ctx context.Context) error {
+	s.seqWriteMu.Lock()
+	defer s.seqWriteMu.Unlock()
+
+	return s.opts.Store.SetSeq(ctx, s.idUint(), s.Seq())
+}
@@ -3,9 +3,10 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net/url"
+	"runtime/pprof"
 	"strconv"
 	"sync"
 	"time"
@@ -25,12 +26,83 @@ type Shard struct {
 
 	id            string
 	opts          *ShardOptions
+	logLevel      int32
 	limiter       Limiter
 	packets       *sync.Pool
 	lastHeartbeat time.Time
+	latency       *latencyRing
+	clock         receiveClock
+	usage         usage
 
 	connMu sync.Mutex
+	epoch  uint64
 	acks   chan struct{}
+
+	stateMu   sync.RWMutex
+	seq       uint
+	sessionID string
+	resumeURL string
+	// resumeDialFailures counts consecutive failures to dial resumeURL
+	resumeDialFailures int
+
+	// resuming is set while a resume is awaiting its outcome; resumeFailed is set while the
+	// identify that replaces a failed resume is awaiting READY
+	resuming     bool
+	resumeFailed bool
+
+	// identifyNext makes the next connection identify instead of resuming
+	identifyNext bool
+
+	// invalidSessions counts non-resumable invalid sessions since a session was last established;
+	// started is set once any session has been established
+	invalidSessions int
+	started         bool
+
+	// closeReason is why the current connection is expected to end, if known
+	closeReason error
+	// closing is set once the shard has been closed resumably and should no longer reconnect
+	closing bool
+	// established is set once a connection establishes a session; attempt is the reconnect attempt
+	// awaiting its outcome, if any
+	established bool
+	attempt     *ReconnectAttempt
+	// phase is the shard's startup phase
+	phase ShardPhase
+
+	presenceLimiter Limiter
+	presenceMu      sync.Mutex
+	pendingPresence interface{}
+	presenceQueued  bool
+	lastPresence    interface{}
+
+	standbyMu    sync.Mutex
+	standby      *standby
+	standbyTaken chan struct{}
+
+	waitersMu sync.Mutex
+	waiters   map[*dispatchWaiter]struct{}
+
+	// replaying is set while Resume replays the pause buffer; dispatches are still buffered until
+	// it's empty so that they're handled in order
+	pauseMu     sync.Mutex
+	paused      bool
+	replaying   bool
+	pauseBuffer []receivedPacket
+
+	sendQueueMu    sync.Mutex
+	sendReady      bool
+	sendGeneration uint64
+	sendQueue      []queuedPacket
+
+	seqPersistMu    sync.Mutex
+	unpersistedSeqs int
+	seqPersistTimer Timer
+	seqWriteMu      sync.Mutex
+
+	chaosMu sync.Mutex
+	chaos   chaos
+
+	handlerCtx context.Context
 }
 
 // NewShard creates a new Gateway shard
@@ -38,23 +110,76 @@ func NewShard(opts *ShardOptions) *Shard {
 	opts.init()
 
 	return &Shard{
-		opts:    opts,
-		limiter: NewDefaultLimiter(120, time.Minute),
+		opts:            opts,
+		logLevel:        int32(opts.LogLevel),
+		limiter:         NewDefaultLimiter(120, time.Minute),
+		presenceLimiter: NewDefaultLimiter(5, 20*time.Second),
 		packets: &sync.Pool{
 			New: func() interface{} {
 				return new(types.ReceivePacket)
 			},
 		},
-		id:   strconv.Itoa(opts.Identify.Shard[0]),
-		acks: make(chan struct{}),
+		id:      strconv.Itoa(opts.Identify.Shard[0]),
+		acks:    make(chan struct{}),
+		latency: newLatencyRing(opts.LatencySamples),
+		clock:   receiveClock{lags: newLatencyRing(opts.LatencySamples)},
+
+		standbyTaken: make(chan struct{}, 1),
+		waiters:      make(map[*dispatchWaiter]struct{}),
 	}
 }
 
-// Open starts a new session. Any errors are fatal.
+// Open starts a new session. Any errors are fatal. Returns nil if the shard was closed resumably.
 func (s *Shard) Open(ctx context.Context) (err error) {
+	pprof.Do(ctx, pprof.Labels("shard", s.id), func(ctx context.Context) {
+		err = s.open(ctx)
+	})
+	return
+}
+
+func (s *Shard) open(ctx context.Context) (err error) {
+	s.setHandlerContext(ctx)
+
+	if s.opts.WarmStandby {
+		standbyCtx, cancelStandby := context.WithCancel(ctx)
+		defer cancelStandby()
+		go s.maintainStandby(standbyCtx)
+	}
+
+	stats.CohortShards.WithLabelValues(s.cohort()).Inc()
+	defer stats.CohortShards.WithLabelValues(s.cohort()).Dec()
+
+	var (
+		attempt int
+		backoff time.Duration
+	)
+
 	err = s.connect(ctx)
-	for s.handleClose(err) {
-		err = s.connect(ctx)
+	for s.handleClose(err) && !s.isClosing() {
+		if s.takeEstablished() {
+			attempt = 0
+		}
+
+		attempt++
+		if s.opts.MaxReconnectAttempts > 0 && attempt > s.opts.MaxReconnectAttempts {
+			err = ErrMaxRetriesExceeded
+		} else {
+			backoff, err = s.reconnect(ctx, attempt, backoff)
+		}
+		if err == ErrMaxRetriesExceeded {
+			s.log(LogLevelError, "giving up after %d reconnect attempts", attempt-1)
+			break
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if s.isClosing() {
+		err = nil
+	} else if ctx.Err() == nil {
+		s.emitLifecycle(LifecycleEvent{Type: LifecycleGaveUp, Err: err})
 	}
 	return
 }
@@ -65,45 +190,84 @@ func (s *Shard) connect(ctx context.Context) (err error) {
 		return ErrGatewayAbsent
 	}
 
-	url := s.gatewayURL()
-	s.log(LogLevelInfo, "Connecting using URL: %s", url)
+	if s.isClosing() {
+		return ErrShardClosing
+	}
+	s.setPhase(ShardConnecting)
+	s.emitLifecycle(LifecycleEvent{Type: LifecycleConnecting})
 
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return
+	conn, helloAge := s.takeStandby()
+	if conn != nil {
+		s.log(LogLevelInfo, "Connecting using standby connection")
+	} else {
+		url, resuming := s.dialURL()
+		s.log(LogLevelInfo, "Connecting using URL: %s", url)
+
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if resuming {
+			s.resumeDialed(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		compressor, err := s.newCompressor()
+		if err != nil {
+			ws.Close()
+			return err
+		}
+		conn = NewConnectionContext(ctx, ws, compressor)
+		if s.opts.EgressLimiter != nil {
+			conn.limitEgress(s.opts.EgressLimiter, s.id, s.opts.TimeSource)
+		}
 	}
-	s.conn = NewConnection(conn, compression.NewZstd())
+
+	epoch := s.setConn(conn)
+	defer func() {
+		if conn := s.clearConn(epoch); conn != nil {
+			conn.terminate()
+		}
+		s.flushSeq()
+	}()
 
 	heartbeatCtx, cancelHeartbeat := context.WithCancel(ctx)
 	defer cancelHeartbeat()
 
-	err = s.expectPacket(ctx, types.GatewayOpHello, types.GatewayEventNone, s.handleHello(heartbeatCtx))
+	helloTimer := s.opts.TimeSource.AfterFunc(s.opts.HelloTimeout, func() {
+		conn.terminate()
+	})
+	err = s.expectPacket(ctx, types.GatewayOpHello, types.GatewayEventNone, s.handleHello(heartbeatCtx, epoch, helloAge))
+	if !helloTimer.Stop() {
+		err = ErrHelloTimeout
+	}
 	if err != nil {
 		return
 	}
 
-	seq, err := s.opts.Store.GetSeq(ctx, s.idUint())
-	if err != nil {
-		s.log(LogLevelWarn, "Unable to retrive sequence data for login: %s", err)
+	// sessions started by this process are resumed from memory, which is ahead of the store
+	sessionID, seq := s.SessionID(), s.Seq()
+	if sessionID == "" {
+		sessionID, seq = s.storedSession(ctx)
 	}
-
-	sessionID, err := s.opts.Store.GetSession(ctx, s.idUint())
-	if err != nil {
-		s.log(LogLevelWarn, "Unable to retrieve session ID for login: %s", err)
+	if s.takeIdentifyNext() {
+		sessionID, seq = "", 0
 	}
+	s.setSeq(seq)
+	s.setSession(sessionID)
 
 	s.log(LogLevelDebug, "session \"%s\", seq %d", sessionID, seq)
-	errs := make(chan error)
+	errs := make(chan error, 2)
 
+	s.setPhase(ShardIdentifying)
 	go func() {
-		if sessionID == "" && seq == 0 {
-			if err = s.sendIdentify(); err != nil {
-				errs <- err
-			}
+		var err error
+		if sessionID == "" {
+			err = s.sendIdentify()
 		} else {
-			if err = s.sendResume(ctx); err != nil {
-				errs <- err
-			}
+			err = s.sendResume()
+		}
+		if err != nil {
+			errs <- err
 		}
 	}()
 
@@ -116,7 +280,7 @@ func (s *Shard) connect(ctx context.Context) (err error) {
 	go func() {
 		for {
 			err = s.readPacket(ctx, nil)
-			if err != nil {
+			if err != nil && !s.skipPacketError(err) {
 				errs <- err
 				break
 			}
@@ -128,13 +292,84 @@ func (s *Shard) connect(ctx context.Context) (err error) {
 
 // CloseWithReason closes the connection and logs the reason
 func (s *Shard) CloseWithReason(code int, reason error) error {
+	conn, err := s.activeConn()
+	if err != nil {
+		return err
+	}
+
 	s.log(LogLevelWarn, "%s: closing connection", reason)
-	return s.conn.CloseWithCode(code)
+	s.setCloseReason(reason)
+	return conn.CloseWithCode(code)
+}
+
+// CloseResumable closes the connection without invalidating the session, so that it can be resumed
+// later (e.g. after a restart), and stops the shard from reconnecting
+func (s *Shard) CloseResumable() error {
+	s.stateMu.Lock()
+	s.closing = true
+	s.stateMu.Unlock()
+
+	conn, err := s.activeConn()
+	if err != nil {
+		return nil
+	}
+
+	s.setCloseReason(ErrShardClosing)
+	s.log(LogLevelInfo, "Closing connection resumably")
+	return conn.CloseWithCode(websocket.CloseServiceRestart)
+}
+
+// Stop closes the connection, invalidating the session, and stops the shard from reconnecting
+func (s *Shard) Stop() error {
+	s.stateMu.Lock()
+	s.closing = true
+	s.stateMu.Unlock()
+
+	conn, err := s.activeConn()
+	if err != nil {
+		return nil
+	}
+
+	s.setCloseReason(ErrShardClosing)
+	s.log(LogLevelInfo, "Stopping")
+	return conn.Close()
+}
+
+// Reidentify closes the connection and starts a new session instead of resuming the current one
+func (s *Shard) Reidentify() error {
+	s.stateMu.Lock()
+	s.identifyNext = true
+	s.stateMu.Unlock()
+
+	return s.CloseWithReason(websocket.CloseNormalClosure, ErrReidentifyRequested)
+}
+
+// takeIdentifyNext returns whether a new session was requested since the last call
+func (s *Shard) takeIdentifyNext() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	identify := s.identifyNext
+	s.identifyNext = false
+	return identify
+}
+
+// isClosing returns whether the shard has been closed resumably
+func (s *Shard) isClosing() bool {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	return s.closing
 }
 
 // Close closes the current session
 func (s *Shard) Close() (err error) {
-	if err = s.conn.Close(); err != nil {
+	conn, err := s.activeConn()
+	if err != nil {
+		return
+	}
+
+	if err = conn.Close(); err != nil {
 		return
 	}
 
@@ -143,17 +378,35 @@ func (s *Shard) Close() (err error) {
 }
 
 func (s *Shard) readPacket(ctx context.Context, fn func(*types.ReceivePacket) error) (err error) {
-	d, err := s.conn.Read()
+	conn, err := s.activeConn()
 	if err != nil {
 		return
 	}
 
+	var d []byte
+	for keep := false; !keep; {
+		if d, err = conn.Read(); err != nil {
+			return s.injectReadFault(err)
+		}
+		d, keep = s.injectFrameFaults(d)
+	}
+	receivedAt := s.opts.TimeSource.Now()
+	if s.trackUsage(len(d)) {
+		defer func() {
+			s.recordHandlerWallTime(s.opts.TimeSource.Now().Sub(receivedAt))
+		}()
+	}
+
+	if s.opts.MaxPacketSize > 0 && len(d) > s.opts.MaxPacketSize {
+		return &PacketError{"oversized", fmt.Errorf("%d bytes exceeds maximum of %d", len(d), s.opts.MaxPacketSize)}
+	}
+
 	p := s.packets.Get().(*types.ReceivePacket)
 	defer s.packets.Put(p)
 
-	err = json.Unmarshal(d, p)
+	err = scanPacket(d, p)
 	if err != nil {
-		return
+		return &PacketError{"decode", err}
 	}
 
 	// remove event from any previous OP 0s that used this packet
@@ -166,9 +419,8 @@ func (s *Shard) readPacket(ctx context.Context, fn func(*types.ReceivePacket) er
 	// record packet received
 	stats.PacketsReceived.WithLabelValues(string(p.Event), strconv.Itoa(int(p.Op)), s.id).Inc()
 
-	if s.opts.OnPacket != nil {
-		s.opts.OnPacket(p)
-	}
+	s.recordReceived(p, receivedAt)
+	s.deliver(p, receivedAt)
 
 	err = s.handlePacket(ctx, p)
 	if err != nil {
@@ -209,7 +461,7 @@ func (s *Shard) handlePacket(ctx context.Context, p *types.ReceivePacket) (err e
 		return s.handleDispatch(ctx, p)
 
 	case types.GatewayOpHeartbeat:
-		return s.sendHeartbeat(ctx)
+		return s.sendHeartbeat()
 
 	case types.GatewayOpReconnect:
 		if err = s.CloseWithReason(types.CloseUnknownError, ErrReconnectReceived); err != nil {
@@ -223,7 +475,7 @@ func (s *Shard) handlePacket(ctx context.Context, p *types.ReceivePacket) (err e
 		}
 
 		if *resumable {
-			if err = s.sendResume(ctx); err != nil {
+			if err = s.sendResume(); err != nil {
 				return
 			}
 
@@ -231,43 +483,67 @@ func (s *Shard) handlePacket(ctx context.Context, p *types.ReceivePacket) (err e
 			return
 		}
 
-		time.Sleep(time.Second * time.Duration(rand.Intn(5)+1))
-		if err = s.sendIdentify(); err != nil {
-			return
-		}
+		s.resumeOutcome(types.GatewayOpInvalidSession)
+		s.setCloseReason(ErrInvalidSession)
 
-		s.log(LogLevelDebug, "Sent identify in response to invalid non-resumable session")
+		return s.handleInvalidSession(ctx)
 
 	case types.GatewayOpHeartbeatACK:
-		if s.lastHeartbeat.Unix() != 0 {
-			// record latest gateway ping
-			s.Ping = time.Since(s.lastHeartbeat)
-			stats.Ping.WithLabelValues(s.id).Observe(float64(s.Ping.Nanoseconds()) / 1e6)
+		if !s.delayAck() {
+			s.handleHeartbeatAck(nil)
 		}
-
-		s.log(LogLevelDebug, "Heartbeat ACK (RTT %s)", s.Ping)
-		s.acks <- struct{}{}
 	}
 
 	return
 }
 
+// handleHeartbeatAck records the gateway's latency and passes the ACK to the heartbeater, unless
+// done is closed first
+func (s *Shard) handleHeartbeatAck(done <-chan struct{}) {
+	if s.lastHeartbeat.Unix() != 0 {
+		// record latest gateway ping
+		s.Ping = s.opts.TimeSource.Now().Sub(s.lastHeartbeat)
+		s.latency.add(s.Ping)
+		stats.Ping.WithLabelValues(s.id).Observe(float64(s.Ping.Nanoseconds()) / 1e6)
+		stats.CohortPing.WithLabelValues(s.cohort()).Observe(float64(s.Ping.Nanoseconds()) / 1e6)
+	}
+
+	s.log(LogLevelDebug, "Heartbeat ACK (RTT %s)", s.Ping)
+	select {
+	case s.acks <- struct{}{}:
+	case <-done:
+	}
+}
+
 // handleDispatch handles dispatch packets
 func (s *Shard) handleDispatch(ctx context.Context, p *types.ReceivePacket) (err error) {
-	if err = s.opts.Store.SetSeq(ctx, s.idUint(), uint(p.Seq)); err != nil {
+	stats.CohortDispatches.WithLabelValues(s.cohort()).Inc()
+	s.trackUnknownEvent(p)
+	s.validateDispatch(p)
+	s.notifyWaiters(p)
+
+	s.setSeq(uint(p.Seq))
+	if err = s.persistSeq(ctx); err != nil {
 		return
 	}
 
 	switch p.Event {
 	case types.GatewayEventReady:
-		r := new(types.Ready)
+		r := new(ready)
 		if err = json.Unmarshal(p.Data, r); err != nil {
 			return
 		}
 
+		s.resumeOutcome(types.GatewayOpDispatch)
+		s.setCloseReason(nil)
+		s.markEstablished()
+		s.setSession(r.SessionID)
+		s.setResumeURL(r.ResumeGatewayURL)
 		if err = s.opts.Store.SetSession(ctx, s.idUint(), r.SessionID); err != nil {
 			return
 		}
+		s.resyncPresence()
+		s.emitLifecycle(LifecycleEvent{Type: LifecycleReady})
 
 		s.log(LogLevelDebug, "Session ID: %s", r.SessionID)
 		s.log(LogLevelDebug, "Using version %d", r.Version)
@@ -279,13 +555,26 @@ func (s *Shard) handleDispatch(ctx context.Context, p *types.ReceivePacket) (err
 			return
 		}
 
+		s.resumeOutcome(types.GatewayOpResume)
+		s.setCloseReason(nil)
+		s.markEstablished()
+		if s.opts.ResyncPresenceOnResume {
+			s.resyncPresence()
+		}
+		s.emitLifecycle(LifecycleEvent{Type: LifecycleResumed})
+
 		s.logTrace(r.Trace)
+
+	case "GUILD_CREATE", "GUILD_DELETE":
+		s.trackGuild(p)
 	}
 
 	return
 }
 
-func (s *Shard) handleHello(ctx context.Context) func(*types.ReceivePacket) error {
+// handleHello starts heartbeating according to HELLO. helloAge is the time since HELLO was
+// received, for connections which were on standby.
+func (s *Shard) handleHello(ctx context.Context, epoch uint64, helloAge time.Duration) func(*types.ReceivePacket) error {
 	return func(p *types.ReceivePacket) (err error) {
 		h := new(types.Hello)
 		if err = json.Unmarshal(p.Data, h); err != nil {
@@ -293,14 +582,33 @@ func (s *Shard) handleHello(ctx context.Context) func(*types.ReceivePacket) erro
 		}
 
 		s.logTrace(h.Trace)
-		go s.startHeartbeater(ctx, time.Duration(h.HeartbeatInterval)*time.Millisecond)
+		go s.startHeartbeater(ctx, epoch, time.Duration(h.HeartbeatInterval)*time.Millisecond, helloAge)
 		return
 	}
 }
 
 // handleClose handles the WebSocket close event. Returns whether the session is recoverable.
 func (s *Shard) handleClose(err error) (recoverable bool) {
-	recoverable = !websocket.IsCloseError(
+	stats.CohortDisconnects.WithLabelValues(s.cohort()).Inc()
+	code, reason := s.recordDisconnect(err)
+	s.setSendReady(false)
+	s.setPhase(ShardConnecting)
+	defer func() {
+		s.emitDisconnected(err, code, reason, recoverable)
+	}()
+
+	if s.downgradeIntents(err) {
+		return true
+	}
+
+	// these sessions can't be resumed
+	if websocket.IsCloseError(err, types.CloseInvalidSeq, types.CloseSessionTimeout) {
+		s.stateMu.Lock()
+		s.identifyNext = true
+		s.stateMu.Unlock()
+	}
+
+	recoverable = !errors.Is(err, ErrRepeatedInvalidSession) && !websocket.IsCloseError(
 		err,
 		types.CloseAuthenticationFailed,
 		types.CloseInvalidShard,
@@ -326,42 +634,94 @@ func (s *Shard) SendPacket(op types.GatewayOp, data interface{}) error {
 	})
 }
 
-// Send sends a pre-prepared packet
+// Send sends a pre-prepared packet. Presence updates are queued and sent according to the
+// presence ratelimit (see UpdatePresence). Read-only shards only send heartbeats, identifies, and
+// resumes, returning ErrReadOnly for anything else.
 func (s *Shard) Send(p *types.SendPacket) error {
+	if err := s.refuseSend(p.Op); err != nil {
+		return err
+	}
+
+	if p.Op == types.GatewayOpStatusUpdate {
+		s.queuePresence(p.Data)
+		return nil
+	}
+
+	return s.send(p)
+}
+
+// send sends a packet, subject only to the global ratelimit. Packets sent without a session are
+// queued until one is established.
+func (s *Shard) send(p *types.SendPacket) error {
+	if queued, err := s.enqueue(p); queued || err != nil {
+		return err
+	}
+
+	return s.write(p)
+}
+
+// write writes a packet to the connection
+func (s *Shard) write(p *types.SendPacket) error {
 	d, err := json.Marshal(p)
 	if err != nil {
 		return err
 	}
 
 	s.limiter.Lock()
-	s.connMu.Lock()
-	defer s.connMu.Unlock()
+	conn, err := s.activeConn()
+	if err != nil {
+		return err
+	}
 
 	// record packet sent
 	defer stats.PacketsSent.WithLabelValues("", strconv.Itoa(int(p.Op)), s.id).Inc()
 
-	s.log(LogLevelDebug, "-> op:%d d:%+v", p.Op, p.Data)
-	_, err = s.conn.Write(d)
+	s.log(LogLevelDebug, "-> op:%d d:%s", p.Op, d)
+
+	// a heartbeat delayed by a saturated egress limiter would get the session dropped as a zombie
+	if p.Op == types.GatewayOpHeartbeat {
+		return conn.writeUnlimited(d)
+	}
+	_, err = conn.Write(d)
 	return err
 }
 
 // sendIdentify sends an identify packet
 func (s *Shard) sendIdentify() error {
 	s.opts.IdentifyLimiter.Lock()
-	return s.SendPacket(types.GatewayOpIdentify, s.opts.Identify)
+	return s.SendPacket(types.GatewayOpIdentify, s.identifyPayload())
 }
 
-// sendResume sends a resume packet
-func (s *Shard) sendResume(ctx context.Context) error {
+// storedSession returns the session and sequence persisted in the store, if any, so that a session
+// started before a restart can be resumed
+func (s *Shard) storedSession(ctx context.Context) (sessionID string, seq uint) {
 	sessionID, err := s.opts.Store.GetSession(ctx, s.idUint())
+	if errors.Is(err, ErrUndecryptableSession) {
+		s.log(LogLevelWarn, "Discarding stored session: %s", err)
+		return "", 0
+	}
 	if err != nil {
-		return err
+		s.log(LogLevelWarn, "Unable to retrieve session ID for login: %s", err)
+		return "", 0
+	}
+	if sessionID == "" {
+		return "", 0
 	}
 
-	seq, err := s.opts.Store.GetSeq(ctx, s.idUint())
+	seq, err = s.opts.Store.GetSeq(ctx, s.idUint())
 	if err != nil {
-		return err
+		s.log(LogLevelWarn, "Unable to retrive sequence data for login: %s", err)
+		return "", 0
 	}
+	return
+}
+
+// sendResume sends a resume packet for the current session
+func (s *Shard) sendResume() error {
+	s.stateMu.Lock()
+	s.resuming = true
+	sessionID, seq := s.sessionID, s.seq
+	s.stateMu.Unlock()
 
 	s.log(LogLevelDebug, "attempting to resume session")
 	return s.SendPacket(types.GatewayOpResume, &types.Resume{
@@ -371,21 +731,26 @@ func (s *Shard) sendResume(ctx context.Context) error {
 	})
 }
 
-// sendHeartbeat sends a heartbeat packet
-func (s *Shard) sendHeartbeat(ctx context.Context) error {
-	seq, err := s.opts.Store.GetSeq(ctx, s.idUint())
-	if err != nil {
-		return err
+// sendHeartbeat sends a heartbeat packet with the latest sequence received
+func (s *Shard) sendHeartbeat() error {
+	s.lastHeartbeat = s.opts.TimeSource.Now()
+	return s.SendPacket(types.GatewayOpHeartbeat, s.Seq())
+}
+
+// startHeartbeater calls sendHeartbeat on the provided interval. The first heartbeat is sent at a
+// random point within the first interval after HELLO (which was received helloAge ago) so that
+// shards started together don't heartbeat in lockstep. The heartbeater stops once the connection
+// from the given epoch is replaced.
+func (s *Shard) startHeartbeater(ctx context.Context, epoch uint64, interval, helloAge time.Duration) {
+	var jitter time.Duration
+	if remaining := interval - helloAge; remaining > 0 {
+		jitter = time.Duration(s.opts.Random.Int63n(int64(remaining)))
 	}
 
-	s.lastHeartbeat = time.Now()
-	return s.SendPacket(types.GatewayOpHeartbeat, seq)
-}
+	phase := s.opts.TimeSource.NewTimer(jitter)
+	defer phase.Stop()
 
-// startHeartbeater calls sendHeartbeat on the provided interval
-func (s *Shard) startHeartbeater(ctx context.Context, interval time.Duration) {
-	t := time.NewTicker(interval)
-	defer t.Stop()
+	var ticks <-chan time.Time
 
 	acked := true
 	s.log(LogLevelInfo, "starting heartbeat at interval %s", interval)
@@ -395,14 +760,34 @@ func (s *Shard) startHeartbeater(ctx context.Context, interval time.Duration) {
 		select {
 		case <-s.acks:
 			acked = true
-		case <-t.C:
+		case <-phase.C():
+			if _, err := s.epochConn(epoch); err != nil {
+				return
+			}
+
+			t := s.opts.TimeSource.NewTicker(interval)
+			defer t.Stop()
+			ticks = t.C()
+
+			s.log(LogLevelDebug, "sending first heartbeat")
+			if err := s.sendHeartbeat(); err != nil {
+				s.log(LogLevelError, "error sending automatic heartbeat: %s", err)
+				return
+			}
+			acked = false
+
+		case <-ticks:
+			if _, err := s.epochConn(epoch); err != nil {
+				return
+			}
+
 			if !acked {
 				s.CloseWithReason(types.CloseSessionTimeout, ErrHeartbeatUnacknowledged)
 				return
 			}
 
 			s.log(LogLevelDebug, "sending automatic heartbeat")
-			if err := s.sendHeartbeat(ctx); err != nil {
+			if err := s.sendHeartbeat(); err != nil {
 				s.log(LogLevelError, "error sending automatic heartbeat: %s", err)
 				return
 			}
@@ -414,15 +799,111 @@ func (s *Shard) startHeartbeater(ctx context.Context, interval time.Duration) {
 	}
 }
 
+// newCompressor creates the compressor for a new connection
+func (s *Shard) newCompressor() (compression.Compressor, error) {
+	if s.opts.CompressionDictionary != nil {
+		return compression.NewZstdDict(s.opts.CompressionDictionary)
+	}
+	return compression.NewZstd(), nil
+}
+
 // gatewayURL returns the Gateway URL with appropriate query parameters
 func (s *Shard) gatewayURL() string {
+	return s.formatURL(s.Gateway.URL)
+}
+
+// formatURL adds the appropriate query parameters to a gateway URL
+func (s *Shard) formatURL(base string) string {
 	query := url.Values{
 		"v":        {strconv.FormatUint(uint64(s.opts.Version), 10)},
 		"encoding": {"json"},
 		"compress": {"zstd-stream"},
 	}
 
-	return s.Gateway.URL + "/?" + query.Encode()
+	return base + "/?" + query.Encode()
+}
+
+// Seq returns the sequence number of the latest dispatch received by this shard
+func (s *Shard) Seq() uint {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	return s.seq
+}
+
+// SessionID returns the session ID currently in use by this shard
+func (s *Shard) SessionID() string {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	return s.sessionID
+}
+
+// ResumeURL returns the URL Discord provided for resuming the current session
+func (s *Shard) ResumeURL() string {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	return s.resumeURL
+}
+
+func (s *Shard) setSeq(seq uint) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.seq = seq
+}
+
+func (s *Shard) setSession(id string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.sessionID = id
+}
+
+func (s *Shard) setResumeURL(url string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.resumeURL = url
+	s.resumeDialFailures = 0
+}
+
+// resumeOutcome records the outcome of a session start, given the op that concluded it: a
+// dispatch (READY), a resume (RESUMED), or an invalid session
+func (s *Shard) resumeOutcome(op types.GatewayOp) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	switch op {
+	case types.GatewayOpResume:
+		if s.resuming {
+			stats.SessionOutcomes.WithLabelValues("resumed", s.id).Inc()
+		}
+		s.resuming = false
+
+	case types.GatewayOpInvalidSession:
+		if s.resuming {
+			stats.SessionOutcomes.WithLabelValues("resume_failed", s.id).Inc()
+			s.resumeFailed = true
+		}
+		s.resuming = false
+
+	case types.GatewayOpDispatch:
+		if !s.resumeFailed {
+			stats.SessionOutcomes.WithLabelValues("identified", s.id).Inc()
+		}
+		s.resuming = false
+		s.resumeFailed = false
+	}
+}
+
+// cohort returns the metrics cohort this shard belongs to
+func (s *Shard) cohort() string {
+	if s.opts.Canary {
+		return "canary"
+	}
+	return "baseline"
 }
 
 func (s *Shard) idUint() uint {
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"sort"
+)
+
+// ShardGroupOptions describes a group of shards managed as a unit (e.g. the shards run by one
+// replica)
+type ShardGroupOptions struct {
+	Name   string
+	Shards []int
+	// Labels are attached to the tags of every shard in the group; shard tags take precedence
+	Labels map[string]string
+}
+
+// ShardGroup is a slice of a manager's shards which can be started, stopped, and drained together
+type ShardGroup struct {
+	manager *Manager
+	opts    ShardGroupOptions
+}
+
+// ShardGroupHealth represents the state of a shard group at a point in time. Connected shards have
+// a connection with a session.
+type ShardGroupHealth struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Shards    []int             `json:"shards"`
+	Running   []int             `json:"running"`
+	Connected []int             `json:"connected"`
+	Healthy   bool              `json:"healthy"`
+}
+
+// Name returns the name of the group
+func (g *ShardGroup) Name() string {
+	return g.opts.Name
+}
+
+// Shards returns the IDs of the shards in the group
+func (g *ShardGroup) Shards() []int {
+	return append([]int(nil), g.opts.Shards...)
+}
+
+// Start starts every shard in the group which isn't running. Shards run in the background until
+// they close, and Manager.Start waits for them; the manager must already be started.
+func (g *ShardGroup) Start() error {
+	m := g.manager
+
+	m.shardsMu.Lock()
+	ctx := m.ctx
+	m.shardsMu.Unlock()
+	if ctx == nil {
+		return ErrManagerNotStarted
+	}
+
+	m.log(LogLevelInfo, "Starting shard group %s", g.opts.Name)
+	for _, id := range g.opts.Shards {
+		m.run(ctx, id)
+	}
+	return nil
+}
+
+// Stop closes every shard in the group, invalidating their sessions
+func (g *ShardGroup) Stop() {
+	g.manager.log(LogLevelInfo, "Stopping shard group %s", g.opts.Name)
+	g.each(func(s *Shard) error {
+		return s.Stop()
+	})
+}
+
+// Drain closes every shard in the group resumably, so that their sessions can be resumed elsewhere
+// or after a restart
+func (g *ShardGroup) Drain() {
+	g.manager.log(LogLevelInfo, "Draining shard group %s", g.opts.Name)
+	g.each(func(s *Shard) error {
+		return s.CloseResumable()
+	})
+}
+
+// each calls fn with every running shard in the group
+func (g *ShardGroup) each(fn func(*Shard) error) {
+	m := g.manager
+
+	m.shardsMu.RLock()
+	defer m.shardsMu.RUnlock()
+
+	for _, id := range g.opts.Shards {
+		if s := m.Shards[id]; s != nil && m.running[id] {
+			if err := fn(s); err != nil {
+				m.log(LogLevelWarn, "Error closing shard %d: %s", id, err)
+			}
+		}
+	}
+}
+
+// Health returns the current state of the group. A group is healthy if every shard is connected.
+func (g *ShardGroup) Health() ShardGroupHealth {
+	m := g.manager
+	h := ShardGroupHealth{
+		Name:      g.opts.Name,
+		Labels:    g.opts.Labels,
+		Shards:    g.Shards(),
+		Running:   []int{},
+		Connected: []int{},
+	}
+
+	m.shardsMu.RLock()
+	for _, id := range g.opts.Shards {
+		s := m.Shards[id]
+		if s == nil || !m.running[id] {
+			continue
+		}
+
+		h.Running = append(h.Running, id)
+		if s.connected() {
+			h.Connected = append(h.Connected, id)
+		}
+	}
+	m.shardsMu.RUnlock()
+
+	h.Healthy = len(h.Connected) == len(h.Shards)
+	return h
+}
+
+// tags returns the tags for a shard in the group, which take precedence over the group labels
+func (g *ShardGroup) tags(tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(g.opts.Labels)+len(tags)+1)
+	merged["group"] = g.opts.Name
+	for k, v := range g.opts.Labels {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Groups returns the manager's shard groups, ordered by name
+func (m *Manager) Groups() []*ShardGroup {
+	groups := make([]*ShardGroup, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].opts.Name < groups[j].opts.Name })
+	return groups
+}
+
+// Group returns the shard group with the given name, or nil if there isn't one
+func (m *Manager) Group(name string) *ShardGroup {
+	return m.groups[name]
+}
+
+// groupOf returns the group containing the shard, or nil if it isn't in one
+func (m *Manager) groupOf(id int) *ShardGroup {
+	for _, g := range m.groups {
+		for _, shard := range g.opts.Shards {
+			if shard == id {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// newGroups creates the configured shard groups
+func (m *Manager) newGroups() map[string]*ShardGroup {
+	groups := make(map[string]*ShardGroup, len(m.opts.Groups))
+	for _, opts := range m.opts.Groups {
+		groups[opts.Name] = &ShardGroup{manager: m, opts: opts}
+	}
+	return groups
+}
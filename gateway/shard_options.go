@@ -1,14 +1,24 @@
 package gateway
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"regexp"
 	"runtime"
 	"time"
 
+	"github.com/spec-tacles/gateway/schema"
 	"github.com/spec-tacles/go/types"
 )
 
+// DefaultHelloTimeout is the default time to wait for HELLO after connecting
+const DefaultHelloTimeout = 20 * time.Second
+
+// DefaultResumeURLMaxFailures is the default number of failures to dial the resume URL before
+// falling back to the main gateway URL
+const DefaultResumeURLMaxFailures = 3
+
 // Retryer calculates the wait time between retries
 type Retryer interface {
 	FirstTimeout() time.Duration
@@ -22,12 +32,148 @@ type ShardOptions struct {
 	Retryer  Retryer
 	Store    ShardStore
 
+	// OnPacket is called with every packet received. The packet, including its data, must not be
+	// retained after the call returns, unless it's a dispatch received by a shard with a bus. Those
+	// are shared with the bus's subscribers, so they may be retained but must not be modified.
 	OnPacket func(*types.ReceivePacket)
 
+	// OnPacketContext is called with the same packets as OnPacket, along with a context carrying
+	// the shard (see ShardFromContext) and Values. The packet must not be retained after the call
+	// returns.
+	OnPacketContext func(context.Context, *types.ReceivePacket)
+
+	// Bus receives the same dispatches as OnPacket, delivering them to its subscribers.
+	// DispatchMode controls which dispatches OnPacket still receives.
+	Bus          *Bus
+	DispatchMode DispatchMode
+
+	// Values are attached to the context passed to OnPacketContext, so that handlers can access
+	// dependencies such as database handles without global state
+	Values map[interface{}]interface{}
+
+	// SendQueueSize is the maximum number of packets queued while the shard has no session, and
+	// SendQueueExpiry is how long they remain valid. A negative size disables queueing.
+	SendQueueSize   int
+	SendQueueExpiry time.Duration
+
+	// SampleRates are the fractions (0 to 1) of dispatches of each event that are passed to
+	// OnPacket. Events without a rate are always passed.
+	SampleRates map[types.GatewayEvent]float64
+
+	// PauseBufferSize is the maximum number of dispatches buffered while the shard is paused
+	PauseBufferSize int
+
+	// OnUnknownEvent is called with dispatches whose event isn't in KnownEvents. The packet must
+	// not be retained after the call returns.
+	OnUnknownEvent func(*types.ReceivePacket)
+
+	// PacketErrorPolicy determines how packets that can't be decoded are handled. Transport errors
+	// always end the connection.
+	PacketErrorPolicy PacketErrorPolicy
+	// MaxPacketSize is the size in bytes above which decompressed packets are treated as bad; 0
+	// means unlimited
+	MaxPacketSize int
+
+	// Schemas enables validation of dispatch payloads against the schema for their event (see
+	// schema.Bundled); mismatches are logged and passed to OnSchemaMismatch. Intended for
+	// development.
+	Schemas          schema.Registry
+	OnSchemaMismatch func(*types.ReceivePacket, []schema.Mismatch)
+
+	// HelloTimeout is the time to wait for HELLO after connecting before reconnecting
+	HelloTimeout time.Duration
+
+	// ResumeURLMaxFailures is the number of consecutive failures to dial the resume URL provided in
+	// READY after which it's abandoned for the main gateway URL. A negative value disables the
+	// fallback.
+	ResumeURLMaxFailures int
+
+	// InvalidSessionMinDelay and InvalidSessionMaxDelay bound the random delay before identifying
+	// after a non-resumable invalid session
+	InvalidSessionMinDelay time.Duration
+	InvalidSessionMaxDelay time.Duration
+	// MaxStartupInvalidSessions is the number of consecutive invalid sessions received before any
+	// session is established at which the shard stops with ErrRepeatedInvalidSession. A negative
+	// value disables the limit.
+	MaxStartupInvalidSessions int
+
+	// Presence computes the presence to send after each READY (and RESUMED, if
+	// ResyncPresenceOnResume is set). If it's unset or returns nil, the last presence set with
+	// UpdatePresence or Send is sent, if any.
+	Presence               func(*Shard) *types.StatusUpdate
+	ResyncPresenceOnResume bool
+
+	// onPhase is called by the manager's shards when their startup phase changes
+	onPhase func(ShardPhase)
+	// onGuild is called by the manager's shards when a guild joins or leaves them
+	onGuild func(guildID uint64, joined bool)
+
+	// ReadOnly shards consume events but refuse to send anything except heartbeats, identifies, and
+	// resumes (without a presence), so that they can never change the bot's state
+	ReadOnly bool
+
+	// DowngradeIntents are the intents (usually privileged ones) which are removed from the
+	// identify when Discord closes the connection with CloseDisallowedIntents, instead of stopping
+	// the shard
+	DowngradeIntents uint
+
+	// TimeSource and Random drive every timer, wait, and random choice the shard makes; they
+	// default to the real clock and the math/rand global source
+	TimeSource TimeSource
+	Random     Random
+
+	// MaxReconnectAttempts is the number of consecutive failed reconnect attempts after which the
+	// shard gives up with ErrMaxRetriesExceeded. By default, it retries forever (unless the Retryer
+	// returns ErrMaxRetriesExceeded).
+	MaxReconnectAttempts int
+
+	// OnReconnect is called with the outcome of each reconnect attempt
+	OnReconnect func(ReconnectAttempt)
+
+	// OnLifecycle is called whenever the shard connects, starts or resumes a session, disconnects,
+	// or gives up reconnecting
+	OnLifecycle func(LifecycleEvent)
+
 	Logger   *log.Logger
 	LogLevel int
 
+	// RedactPatterns are removed from all log output; DefaultRedactPatterns are always included
+	RedactPatterns []*regexp.Regexp
+
 	IdentifyLimiter Limiter
+
+	// EgressLimiter caps the rate at which bytes are sent, in addition to the packet ratelimit. It
+	// may be shared by many shards to cap their combined bandwidth. Heartbeats aren't limited.
+	EgressLimiter *ByteLimiter
+
+	// SeqPersistEvents and SeqPersistInterval limit how often the sequence is written to the store:
+	// it's written once either many dispatches have been received or that much time has passed
+	// since the first of them, and when the connection closes. Without either, it's written on
+	// every dispatch. Heartbeats and resumes within the process always use the latest sequence.
+	SeqPersistEvents   int
+	SeqPersistInterval time.Duration
+
+	// UsageSampleRate is the number of packets per packet whose handling is timed for
+	// ShardUsage.HandlerWallTime. A negative value disables timing.
+	UsageSampleRate int
+
+	// LatencySamples is the number of heartbeat RTTs kept for Shard.Latency
+	LatencySamples int
+
+	// Tags are arbitrary metadata (e.g. region, customer, or tier) attached to the shard's logs,
+	// snapshots, and broker envelopes
+	Tags map[string]string
+
+	// Canary marks this shard as a canary for the purpose of cohort metrics
+	Canary bool
+
+	// CompressionDictionary is a zstd dictionary used to decompress packets. Discord doesn't
+	// compress with a dictionary, so this only applies to gateways (such as proxies) that use the
+	// same one.
+	CompressionDictionary []byte
+
+	// WarmStandby keeps a pre-dialed connection ready to take over when the active one drops
+	WarmStandby bool
 }
 
 func (opts *ShardOptions) init() {
@@ -39,11 +185,22 @@ func (opts *ShardOptions) init() {
 		opts.Logger = DefaultLogger
 	}
 	opts.Logger = ChildLogger(opts.Logger, fmt.Sprintf("[shard %d]", opts.Identify.Shard[0]))
+	if len(opts.Tags) > 0 {
+		opts.Logger = ChildLogger(opts.Logger, formatLabels(opts.Tags))
+	}
 
 	if opts.Retryer == nil {
 		opts.Retryer = defaultRetryer{}
 	}
 
+	if opts.TimeSource == nil {
+		opts.TimeSource = realTime{}
+	}
+
+	if opts.Random == nil {
+		opts.Random = globalRandom{}
+	}
+
 	if opts.IdentifyLimiter == nil {
 		opts.IdentifyLimiter = NewDefaultLimiter(1, 5*time.Second)
 	}
@@ -61,6 +218,41 @@ func (opts *ShardOptions) init() {
 	if opts.Store == nil {
 		opts.Store = NewLocalShardStore()
 	}
+
+	opts.RedactPatterns = append(append([]*regexp.Regexp{}, DefaultRedactPatterns...), opts.RedactPatterns...)
+
+	if opts.SendQueueSize == 0 {
+		opts.SendQueueSize = DefaultSendQueueSize
+	}
+
+	if opts.SendQueueExpiry == 0 {
+		opts.SendQueueExpiry = DefaultSendQueueExpiry
+	}
+
+	if opts.HelloTimeout <= 0 {
+		opts.HelloTimeout = DefaultHelloTimeout
+	}
+
+	if opts.ResumeURLMaxFailures == 0 {
+		opts.ResumeURLMaxFailures = DefaultResumeURLMaxFailures
+	}
+
+	if opts.InvalidSessionMinDelay == 0 && opts.InvalidSessionMaxDelay == 0 {
+		opts.InvalidSessionMinDelay = DefaultInvalidSessionMinDelay
+		opts.InvalidSessionMaxDelay = DefaultInvalidSessionMaxDelay
+	}
+
+	if opts.MaxStartupInvalidSessions == 0 {
+		opts.MaxStartupInvalidSessions = DefaultMaxStartupInvalidSessions
+	}
+
+	if opts.UsageSampleRate == 0 {
+		opts.UsageSampleRate = DefaultUsageSampleRate
+	}
+
+	if opts.LatencySamples <= 0 {
+		opts.LatencySamples = DefaultLatencySamples
+	}
 }
 
 // clone only clones whatever's necessary
@@ -72,15 +264,11 @@ func (opts ShardOptions) clone() *ShardOptions {
 
 type defaultRetryer struct{}
 
-const maxRetries = 5
 const maxRetry = time.Minute * 5
 
+// defaultRetryer retries forever, doubling the timeout up to maxRetry
 func (defaultRetryer) FirstTimeout() time.Duration { return time.Second }
 func (defaultRetryer) NextTimeout(timeout time.Duration, retries int) (time.Duration, error) {
-	if retries > maxRetries {
-		return 0, ErrMaxRetriesExceeded
-	}
-
 	timeout *= 2
 
 	if timeout > maxRetry {
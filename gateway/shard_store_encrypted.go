@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// EncryptedShardStore wraps another shard store, sealing session identifiers with AES-GCM before
+// they are persisted. Sessions are bound to their shard, so they can't be swapped between shards.
+// Stored sessions which can't be decrypted (such as plaintext ones written before encryption was
+// enabled) are reported with ErrUndecryptableSession, which shards treat as a missing session.
+// Resume URLs are only kept in memory and never reach the store, so they aren't encrypted.
+type EncryptedShardStore struct {
+	ShardStore
+	aead cipher.AEAD
+}
+
+// NewEncryptedShardStore wraps the given store using the given AES key, which must be 16, 24, or
+// 32 bytes long
+func NewEncryptedShardStore(store ShardStore, key []byte) (*EncryptedShardStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedShardStore{
+		ShardStore: store,
+		aead:       aead,
+	}, nil
+}
+
+// GetSession gets and decrypts the session identifier for the given shard, returning an error
+// wrapping ErrUndecryptableSession if it can't be decrypted
+func (s *EncryptedShardStore) GetSession(ctx context.Context, shardID uint) (session string, err error) {
+	sealed, err := s.ShardStore.GetSession(ctx, shardID)
+	if err != nil || sealed == "" {
+		return
+	}
+
+	if session, err = s.open(shardID, sealed); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUndecryptableSession, err)
+	}
+	return
+}
+
+// SetSession encrypts and sets the session identifier for the given shard
+func (s *EncryptedShardStore) SetSession(ctx context.Context, shardID uint, session string) error {
+	sealed, err := s.seal(shardID, session)
+	if err != nil {
+		return err
+	}
+
+	return s.ShardStore.SetSession(ctx, shardID, sealed)
+}
+
+// seal encrypts the plaintext for the shard using a random nonce, which is prepended to the
+// returned ciphertext
+func (s *EncryptedShardStore) seal(shardID uint, plaintext string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	d := s.aead.Seal(nonce, nonce, []byte(plaintext), additionalData(shardID))
+	return base64.StdEncoding.EncodeToString(d), nil
+}
+
+// open decrypts a value produced by seal for the same shard
+func (s *EncryptedShardStore) open(shardID uint, sealed string) (string, error) {
+	d, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	if len(d) < s.aead.NonceSize() {
+		return "", ErrCiphertextTooShort
+	}
+
+	nonce, ciphertext := d[:s.aead.NonceSize()], d[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, additionalData(shardID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// additionalData binds sealed sessions to their shard
+func additionalData(shardID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(shardID), 10))
+}
@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// InstrumentedShardStore wraps another shard store, recording the latency and errors of each call
+type InstrumentedShardStore struct {
+	ShardStore
+}
+
+// NewInstrumentedShardStore wraps the given store
+func NewInstrumentedShardStore(store ShardStore) *InstrumentedShardStore {
+	return &InstrumentedShardStore{store}
+}
+
+// GetSeq gets the current sequence of the given shard
+func (s *InstrumentedShardStore) GetSeq(ctx context.Context, shardID uint) (seq uint, err error) {
+	defer observeStore("get_seq", time.Now(), &err)
+	return s.ShardStore.GetSeq(ctx, shardID)
+}
+
+// SetSeq sets the current sequence of the given shard
+func (s *InstrumentedShardStore) SetSeq(ctx context.Context, shardID uint, seq uint) (err error) {
+	defer observeStore("set_seq", time.Now(), &err)
+	return s.ShardStore.SetSeq(ctx, shardID, seq)
+}
+
+// GetSession gets the session identifier for the given shard
+func (s *InstrumentedShardStore) GetSession(ctx context.Context, shardID uint) (session string, err error) {
+	defer observeStore("get_session", time.Now(), &err)
+	return s.ShardStore.GetSession(ctx, shardID)
+}
+
+// SetSession sets the session identifier for the given shard
+func (s *InstrumentedShardStore) SetSession(ctx context.Context, shardID uint, session string) (err error) {
+	defer observeStore("set_session", time.Now(), &err)
+	return s.ShardStore.SetSession(ctx, shardID, session)
+}
+
+// observeStore records a store call that started at the given time
+func observeStore(operation string, start time.Time, err *error) {
+	stats.ShardStoreSeconds.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		stats.ShardStoreErrors.WithLabelValues(operation).Inc()
+	}
+}
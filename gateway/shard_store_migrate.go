@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// StoreMigrate copies the session and sequence of shards 0 through shardCount-1 from one store to
+// another, so that sessions can be resumed after changing stores. Shards without any stored state
+// are skipped, as are sessions which can't be decrypted. Returns the number of shards copied.
+func StoreMigrate(ctx context.Context, from, to ShardStore, shardCount int) (migrated int, err error) {
+	for id := uint(0); id < uint(shardCount); id++ {
+		var (
+			session string
+			seq     uint
+		)
+
+		session, err = from.GetSession(ctx, id)
+		if errors.Is(err, ErrUndecryptableSession) {
+			session, err = "", nil
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("reading session of shard %d: %w", id, err)
+		}
+
+		if seq, err = from.GetSeq(ctx, id); err != nil {
+			return migrated, fmt.Errorf("reading sequence of shard %d: %w", id, err)
+		}
+
+		if session == "" && seq == 0 {
+			continue
+		}
+
+		if err = to.SetSession(ctx, id, session); err != nil {
+			return migrated, fmt.Errorf("writing session of shard %d: %w", id, err)
+		}
+
+		if err = to.SetSeq(ctx, id, seq); err != nil {
+			return migrated, fmt.Errorf("writing sequence of shard %d: %w", id, err)
+		}
+		migrated++
+	}
+	return
+}
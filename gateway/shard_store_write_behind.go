@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WriteBehindShardStore wraps another shard store, holding sequences in memory and writing them to
+// the underlying store in the background, so that a slow store doesn't delay each dispatch.
+// Sessions are written through immediately.
+type WriteBehindShardStore struct {
+	ShardStore
+
+	ctx      context.Context
+	interval time.Duration
+	onError  func(error)
+
+	mux   sync.Mutex
+	seqs  map[uint]uint
+	dirty map[uint]struct{}
+	timer *time.Timer
+}
+
+// NewWriteBehindShardStore wraps the store. Sequences are written at most once per interval, using
+// the context; errors from these writes are passed to onError, if set.
+func NewWriteBehindShardStore(ctx context.Context, store ShardStore, interval time.Duration, onError func(error)) *WriteBehindShardStore {
+	return &WriteBehindShardStore{
+		ShardStore: store,
+		ctx:        ctx,
+		interval:   interval,
+		onError:    onError,
+		seqs:       make(map[uint]uint),
+		dirty:      make(map[uint]struct{}),
+	}
+}
+
+// GetSeq gets the current sequence of the given shard, including any that haven't been written yet
+func (s *WriteBehindShardStore) GetSeq(ctx context.Context, shardID uint) (seq uint, err error) {
+	seq, err = s.ShardStore.GetSeq(ctx, shardID)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if cached := s.seqs[shardID]; cached > seq {
+		seq, err = cached, nil
+	}
+	return
+}
+
+// SetSeq sets the current sequence of the given shard in memory, ignoring values that are less than
+// the current value, and schedules it to be written
+func (s *WriteBehindShardStore) SetSeq(ctx context.Context, shardID uint, seq uint) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if seq > s.seqs[shardID] {
+		s.seqs[shardID] = seq
+		s.dirty[shardID] = struct{}{}
+		s.schedule()
+	}
+	return nil
+}
+
+// schedule starts the flush timer if it isn't running. The mutex must be held.
+func (s *WriteBehindShardStore) schedule() {
+	if s.timer != nil {
+		return
+	}
+
+	s.timer = time.AfterFunc(s.interval, func() {
+		if err := s.Flush(s.ctx); err != nil && s.onError != nil {
+			s.onError(err)
+		}
+	})
+}
+
+// Pending returns the number of sequences which haven't been written yet
+func (s *WriteBehindShardStore) Pending() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return len(s.dirty)
+}
+
+// Flush writes every pending sequence to the underlying store. Sequences that fail to be written
+// are retried at the next flush.
+func (s *WriteBehindShardStore) Flush(ctx context.Context) (err error) {
+	s.mux.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	pending := make(map[uint]uint, len(s.dirty))
+	for shardID := range s.dirty {
+		pending[shardID] = s.seqs[shardID]
+	}
+	s.dirty = make(map[uint]struct{})
+	s.mux.Unlock()
+
+	for shardID, seq := range pending {
+		if setErr := s.ShardStore.SetSeq(ctx, shardID, seq); setErr != nil {
+			err = setErr
+
+			s.mux.Lock()
+			s.dirty[shardID] = struct{}{}
+			s.schedule()
+			s.mux.Unlock()
+		}
+	}
+	return
+}
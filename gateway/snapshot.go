@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"sort"
+)
+
+// ShardSnapshot represents the state of a shard at a point in time
+type ShardSnapshot struct {
+	ID      int               `json:"id"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Canary  bool              `json:"canary"`
+	Seq     uint              `json:"seq"`
+	Latency LatencyStats      `json:"latency"`
+	Clock   ClockStats        `json:"clock"`
+	Usage   ShardUsage        `json:"usage"`
+}
+
+// Snapshot returns the current state of the shard
+func (s *Shard) Snapshot() ShardSnapshot {
+	return ShardSnapshot{
+		ID:      s.opts.Identify.Shard[0],
+		Tags:    s.Tags(),
+		Canary:  s.opts.Canary,
+		Seq:     s.Seq(),
+		Latency: s.Latency(),
+		Clock:   s.Clock(),
+		Usage:   s.Usage(),
+	}
+}
+
+// Tags returns the tags attached to the shard
+func (s *Shard) Tags() map[string]string {
+	tags := make(map[string]string, len(s.opts.Tags))
+	for k, v := range s.opts.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Snapshot returns the current state of every spawned shard, ordered by ID
+func (m *Manager) Snapshot() []ShardSnapshot {
+	m.shardsMu.RLock()
+	snapshots := make([]ShardSnapshot, 0, len(m.Shards))
+	for _, s := range m.Shards {
+		snapshots = append(snapshots, s.Snapshot())
+	}
+	m.shardsMu.RUnlock()
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots
+}
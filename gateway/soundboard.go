@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/spec-tacles/go/types"
+)
+
+// Soundboard gateway constants
+const (
+	GatewayOpRequestSoundboardSounds types.GatewayOp    = 31
+	GatewayEventSoundboardSounds     types.GatewayEvent = "SOUNDBOARD_SOUNDS"
+)
+
+// RequestSoundboardSounds represents a request soundboard sounds packet
+type RequestSoundboardSounds struct {
+	GuildIDs []string `json:"guild_ids"`
+}
+
+// SoundboardSounds represents a soundboard sounds packet
+type SoundboardSounds struct {
+	GuildID          uint64            `json:"guild_id,string"`
+	SoundboardSounds []json.RawMessage `json:"soundboard_sounds"`
+}
+
+// RequestSoundboardSounds requests the soundboard sounds of the given guilds and waits for all of
+// them to be received. The returned map contains the sounds received for each guild, which is
+// incomplete if the context is done first.
+func (s *Shard) RequestSoundboardSounds(ctx context.Context, guildIDs ...uint64) (sounds map[uint64][]json.RawMessage, err error) {
+	pending := make(map[uint64]struct{}, len(guildIDs))
+	req := &RequestSoundboardSounds{GuildIDs: make([]string, len(guildIDs))}
+	for i, id := range guildIDs {
+		pending[id] = struct{}{}
+		req.GuildIDs[i] = strconv.FormatUint(id, 10)
+	}
+
+	// waiters are called one at a time, and sounds is only read once every guild has been received
+	// or the waiter has been removed
+	sounds = make(map[uint64][]json.RawMessage, len(guildIDs))
+	done := make(chan struct{})
+	if len(pending) == 0 {
+		close(done)
+	}
+
+	remove := s.addWaiter(GatewayEventSoundboardSounds, func(p *types.ReceivePacket) {
+		r := new(SoundboardSounds)
+		if err := json.Unmarshal(p.Data, r); err != nil {
+			s.log(LogLevelWarn, "unable to parse %s: %s", p.Event, err)
+			return
+		}
+
+		// ignore guilds requested by other calls, or already received
+		if _, ok := pending[r.GuildID]; !ok {
+			return
+		}
+		delete(pending, r.GuildID)
+		sounds[r.GuildID] = r.SoundboardSounds
+
+		if len(pending) == 0 {
+			close(done)
+		}
+	})
+	defer remove()
+
+	if err = s.SendPacket(GatewayOpRequestSoundboardSounds, req); err != nil {
+		remove()
+		return nil, err
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		remove()
+		err = ctx.Err()
+	}
+	return
+}
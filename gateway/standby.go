@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spec-tacles/go/types"
+)
+
+// standby is a pre-dialed connection that has received HELLO but has not identified
+type standby struct {
+	conn     *Connection
+	url      string
+	interval time.Duration
+	helloAt  time.Time
+}
+
+// maintainStandby keeps a warm standby connection available until the context is cancelled.
+// Standbys are never identified, so each one is replaced before Discord would time it out for
+// missing heartbeats.
+func (s *Shard) maintainStandby(ctx context.Context) {
+	defer s.discardStandby()
+
+	for {
+		// a standby taken while the previous one was being refreshed leaves a stale signal
+		select {
+		case <-s.standbyTaken:
+		default:
+		}
+
+		sb, err := s.dialStandby(ctx)
+		if err != nil {
+			s.log(LogLevelWarn, "Unable to dial standby connection: %s", err)
+
+			c, stop := s.after(s.opts.Retryer.FirstTimeout())
+			select {
+			case <-c:
+				continue
+			case <-ctx.Done():
+				stop()
+				return
+			}
+		}
+
+		s.standbyMu.Lock()
+		if s.standby != nil {
+			s.standby.conn.terminate()
+		}
+		s.standby = sb
+		s.standbyMu.Unlock()
+		s.log(LogLevelDebug, "Standby connection ready")
+
+		c, stop := s.after(sb.interval / 2)
+		select {
+		case <-c:
+			s.discardStandby()
+		case <-s.standbyTaken:
+			stop()
+		case <-ctx.Done():
+			stop()
+			return
+		}
+	}
+}
+
+// dialStandby dials a new connection to the URL a reconnect would use and waits for its HELLO,
+// which is pushed back onto the connection so that it's handled normally once the standby is taken
+func (s *Shard) dialStandby(ctx context.Context) (sb *standby, err error) {
+	url, _ := s.dialURL()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return
+	}
+
+	compressor, err := s.newCompressor()
+	if err != nil {
+		ws.Close()
+		return
+	}
+
+	conn := NewConnectionContext(ctx, ws, compressor)
+	if s.opts.EgressLimiter != nil {
+		conn.limitEgress(s.opts.EgressLimiter, s.id, s.opts.TimeSource)
+	}
+	d, err := conn.Read()
+	if err != nil {
+		conn.terminate()
+		return
+	}
+
+	p := new(types.ReceivePacket)
+	if err = json.Unmarshal(d, p); err != nil {
+		conn.terminate()
+		return
+	}
+
+	h := new(types.Hello)
+	if p.Op != types.GatewayOpHello {
+		err = ErrUnexpectedPacket
+	} else {
+		err = json.Unmarshal(p.Data, h)
+	}
+	if err != nil {
+		conn.terminate()
+		return
+	}
+
+	conn.unread(d)
+	return &standby{conn, url, time.Duration(h.HeartbeatInterval) * time.Millisecond, s.opts.TimeSource.Now()}, nil
+}
+
+// takeStandby returns the standby connection, if one is available, for use as the active
+// connection, along with the time since it received HELLO. A standby dialed to a different URL
+// than a reconnect would now use (e.g. before the session's resume URL was known) is discarded.
+func (s *Shard) takeStandby() (conn *Connection, age time.Duration) {
+	url, _ := s.dialURL()
+
+	s.standbyMu.Lock()
+	defer s.standbyMu.Unlock()
+
+	if s.standby == nil {
+		return nil, 0
+	}
+
+	if s.standby.url == url {
+		conn = s.standby.conn
+		age = s.opts.TimeSource.Now().Sub(s.standby.helloAt)
+	} else {
+		s.log(LogLevelDebug, "Discarding standby connection dialed to a different gateway URL")
+		s.standby.conn.terminate()
+	}
+	s.standby = nil
+
+	select {
+	case s.standbyTaken <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// discardStandby closes the standby connection, if any
+func (s *Shard) discardStandby() {
+	s.standbyMu.Lock()
+	defer s.standbyMu.Unlock()
+
+	if s.standby != nil {
+		s.standby.conn.terminate()
+		s.standby = nil
+	}
+}
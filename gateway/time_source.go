@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"math/rand"
+	"time"
+)
+
+// TimeSource provides the current time and timers used by shards (for heartbeats, reconnect
+// backoff, invalid session delays, receive times, send queue expiry, sequence persistence, and
+// egress throttling), so that tests and embedders can substitute a controllable clock
+type TimeSource interface {
+	Now() time.Time
+	NewTimer(time.Duration) Timer
+	NewTicker(time.Duration) Ticker
+	AfterFunc(time.Duration, func()) Timer
+}
+
+// Timer is a single event created by a TimeSource. C is nil for timers created by AfterFunc.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is a recurring event created by a TimeSource
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Random provides the randomness used for heartbeat and invalid session jitter, and event sampling.
+// *rand.Rand implements it, but isn't safe for concurrent use by multiple shards on its own.
+type Random interface {
+	Int63n(int64) int64
+	Float64() float64
+}
+
+// realTime is the TimeSource backed by the time package
+type realTime struct{}
+
+func (realTime) Now() time.Time { return time.Now() }
+
+func (realTime) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realTime) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realTime) AfterFunc(d time.Duration, f func()) Timer { return realTimer{time.AfterFunc(d, f)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// globalRandom is the Random backed by the math/rand global source, which is safe for concurrent
+// use
+type globalRandom struct{}
+
+func (globalRandom) Int63n(n int64) int64 { return rand.Int63n(n) }
+func (globalRandom) Float64() float64     { return rand.Float64() }
+
+// after returns a channel which receives once d has passed according to the shard's time source,
+// along with a function to release the timer
+func (s *Shard) after(d time.Duration) (<-chan time.Time, func() bool) {
+	t := s.opts.TimeSource.NewTimer(d)
+	return t.C(), t.Stop
+}
@@ -7,3 +7,9 @@ type UnknownSendPacket struct {
 	GuildID uint64            `json:"guild_id,string"`
 	Packet  *types.SendPacket `json:"packet"`
 }
+
+// ready represents a ready packet, including fields not yet present in types.Ready
+type ready struct {
+	types.Ready
+	ResumeGatewayURL string `json:"resume_gateway_url"`
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/spec-tacles/gateway/stats"
+)
+
+// DefaultUsageSampleRate is the default number of packets per packet whose handling is timed
+const DefaultUsageSampleRate = 100
+
+// ShardUsage represents the work a shard has done to process packets. Neither field is an exact
+// measure of allocations or CPU time, which the runtime doesn't attribute to goroutines; for those,
+// shards are labelled with their ID in CPU profiles (see runtime/pprof).
+type ShardUsage struct {
+	// ReceivedBytes is the total size of decompressed packets received
+	ReceivedBytes uint64 `json:"received_bytes"`
+
+	// HandlerWallTime is the estimated total wall time spent decoding and handling packets,
+	// including OnPacket, extrapolated from a sample of packets. It includes any time handlers
+	// spend blocked.
+	HandlerWallTime time.Duration `json:"handler_wall_time"`
+}
+
+// usage accumulates a shard's resource usage
+type usage struct {
+	packets      uint64
+	bytes        uint64
+	handlerNanos int64
+}
+
+// trackUsage records a received packet of the given size, returning whether its handling should be
+// timed
+func (s *Shard) trackUsage(size int) (sampled bool) {
+	atomic.AddUint64(&s.usage.bytes, uint64(size))
+	stats.ShardReceivedBytes.WithLabelValues(s.id).Add(float64(size))
+
+	if s.opts.UsageSampleRate <= 0 {
+		return false
+	}
+	return atomic.AddUint64(&s.usage.packets, 1)%uint64(s.opts.UsageSampleRate) == 0
+}
+
+// recordHandlerWallTime records the wall time taken to handle a sampled packet
+func (s *Shard) recordHandlerWallTime(elapsed time.Duration) {
+	estimate := elapsed * time.Duration(s.opts.UsageSampleRate)
+	atomic.AddInt64(&s.usage.handlerNanos, int64(estimate))
+	stats.ShardHandlerWallSeconds.WithLabelValues(s.id).Add(estimate.Seconds())
+}
+
+// Usage returns the work the shard has done to process packets
+func (s *Shard) Usage() ShardUsage {
+	return ShardUsage{
+		ReceivedBytes:   atomic.LoadUint64(&s.usage.bytes),
+		HandlerWallTime: time.Duration(atomic.LoadInt64(&s.usage.handlerNanos)),
+	}
+}
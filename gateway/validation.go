@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/gateway/stats"
+	"github.com/spec-tacles/go/types"
+)
+
+// validateDispatch validates the dispatch's payload against its schema, if schema validation is
+// enabled and a schema exists for its event
+func (s *Shard) validateDispatch(p *types.ReceivePacket) {
+	if s.opts.Schemas == nil {
+		return
+	}
+
+	sch, ok := s.opts.Schemas[string(p.Event)]
+	if !ok {
+		return
+	}
+
+	mismatches, err := sch.Validate(p.Data)
+	if err != nil {
+		s.log(LogLevelWarn, "unable to validate %s: %s", p.Event, err)
+		return
+	}
+
+	if len(mismatches) == 0 {
+		return
+	}
+
+	stats.SchemaMismatches.WithLabelValues(string(p.Event), s.id).Add(float64(len(mismatches)))
+	for _, m := range mismatches {
+		s.log(LogLevelWarn, "%s does not match schema: %s", p.Event, m)
+	}
+
+	if s.opts.OnSchemaMismatch != nil {
+		s.opts.OnSchemaMismatch(p, mismatches)
+	}
+}
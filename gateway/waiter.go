@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"github.com/spec-tacles/go/types"
+)
+
+// dispatchWaiter receives dispatches of a single event from the read loop
+type dispatchWaiter struct {
+	event types.GatewayEvent
+	fn    func(*types.ReceivePacket)
+}
+
+// addWaiter registers a function to be called with every dispatch of the given event. The function
+// is called from the read loop, so it must not block or retain the packet. The returned function
+// removes the waiter.
+func (s *Shard) addWaiter(event types.GatewayEvent, fn func(*types.ReceivePacket)) (remove func()) {
+	s.waitersMu.Lock()
+	defer s.waitersMu.Unlock()
+
+	w := &dispatchWaiter{event, fn}
+	s.waiters[w] = struct{}{}
+
+	return func() {
+		s.waitersMu.Lock()
+		defer s.waitersMu.Unlock()
+
+		delete(s.waiters, w)
+	}
+}
+
+// notifyWaiters passes the dispatch to all waiters for its event
+func (s *Shard) notifyWaiters(p *types.ReceivePacket) {
+	s.waitersMu.Lock()
+	defer s.waitersMu.Unlock()
+
+	for w := range s.waiters {
+		if w.event == p.Event {
+			w.fn(p)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package schema
+
+import (
+	"embed"
+	"path"
+	"strings"
+)
+
+//go:embed schemas/*.json
+var bundled embed.FS
+
+// Registry maps dispatch event names to the schema of their payloads
+type Registry map[string]*Schema
+
+// Bundled returns a new registry containing the schemas bundled with this package. Entries may be
+// added, replaced, or removed to override them.
+func Bundled() (Registry, error) {
+	entries, err := bundled.ReadDir("schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	r := make(Registry, len(entries))
+	for _, entry := range entries {
+		d, err := bundled.ReadFile(path.Join("schemas", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := Parse(d)
+		if err != nil {
+			return nil, err
+		}
+
+		r[strings.TrimSuffix(entry.Name(), ".json")] = s
+	}
+
+	return r, nil
+}
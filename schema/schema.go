@@ -0,0 +1,158 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Schema is a subset of JSON Schema sufficient for describing gateway payloads. Supported keywords
+// are type, properties, required, additionalProperties (boolean only), items, and enum.
+type Schema struct {
+	Type                 Types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+}
+
+// Types represents the allowed JSON types of a value, which may be written as a single string or an
+// array of strings
+type Types []string
+
+// UnmarshalJSON unmarshals either a single type or an array of types
+func (t *Types) UnmarshalJSON(d []byte) error {
+	var single string
+	if err := json.Unmarshal(d, &single); err == nil {
+		*t = Types{single}
+		return nil
+	}
+
+	return json.Unmarshal(d, (*[]string)(t))
+}
+
+// Mismatch represents a value that doesn't conform to its schema
+type Mismatch struct {
+	// Path is the location of the value, e.g. "d.author.id"
+	Path    string
+	Message string
+}
+
+func (m Mismatch) String() string {
+	return m.Path + ": " + m.Message
+}
+
+// Parse parses a JSON-encoded schema
+func Parse(d []byte) (s *Schema, err error) {
+	s = new(Schema)
+	err = json.Unmarshal(d, s)
+	return
+}
+
+// Validate validates the JSON document against the schema and returns any mismatches
+func (s *Schema) Validate(d []byte) (mismatches []Mismatch, err error) {
+	dec := json.NewDecoder(bytes.NewReader(d))
+	dec.UseNumber()
+
+	var v interface{}
+	if err = dec.Decode(&v); err != nil {
+		return
+	}
+
+	s.validate("d", v, &mismatches)
+	return
+}
+
+func (s *Schema) validate(path string, v interface{}, mismatches *[]Mismatch) {
+	actual := typeOf(v)
+	if len(s.Type) > 0 && !s.allows(actual) {
+		*mismatches = append(*mismatches, Mismatch{path, fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), actual)})
+		return
+	}
+
+	if len(s.Enum) > 0 && !s.enumContains(v) {
+		*mismatches = append(*mismatches, Mismatch{path, fmt.Sprintf("unexpected value %v", v)})
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*mismatches = append(*mismatches, Mismatch{path, fmt.Sprintf("missing required property %q", name)})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if ok {
+				prop.validate(path+"."+name, v[name], mismatches)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*mismatches = append(*mismatches, Mismatch{path, fmt.Sprintf("unexpected property %q", name)})
+			}
+		}
+
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, mismatches)
+			}
+		}
+	}
+}
+
+// allows returns whether the schema allows the given type
+func (s *Schema) allows(actual string) bool {
+	for _, t := range s.Type {
+		if t == actual {
+			return true
+		}
+
+		if t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// enumContains returns whether the value is one of the schema's enumerated values
+func (s *Schema) enumContains(v interface{}) bool {
+	actual, _ := json.Marshal(v)
+	for _, e := range s.Enum {
+		expected, _ := json.Marshal(e)
+		if bytes.Equal(actual, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type name of a value decoded with json.Decoder.UseNumber
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
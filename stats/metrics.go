@@ -1,6 +1,8 @@
 package stats
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -19,6 +21,200 @@ var (
 		Help:      "Counter of packets sent over all gateway connections.",
 	}, []string{"t", "op", "shard"})
 
+	// Disconnects is a counter of ended connections
+	Disconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "disconnects",
+		Help:      "Counter of ended connections by close code (0 if none) and reason.",
+	}, []string{"code", "reason", "shard"})
+
+	// ReconnectAttempts is a counter of reconnect attempts
+	ReconnectAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "reconnect_attempts",
+		Help:      "Counter of reconnect attempts by outcome and whether the session was resumable.",
+	}, []string{"outcome", "resumable", "shard"})
+
+	// ReconnectBackoff is a histogram of the backoff applied before reconnect attempts
+	ReconnectBackoff = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gateway",
+		Name:      "reconnect_backoff_seconds",
+		Help:      "Backoff applied before each reconnect attempt (in seconds).",
+		Buckets:   []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 300},
+	}, []string{"shard"})
+
+	// ReconnectStreak is a gauge of consecutive reconnect attempts without establishing a session
+	ReconnectStreak = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "reconnect_streak",
+		Help:      "Number of consecutive reconnect attempts made without establishing a session.",
+	}, []string{"shard"})
+
+	// SessionOutcomes is a counter of how sessions were started
+	SessionOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "session_outcomes",
+		Help:      "Counter of session starts by outcome: resumed, resume_failed (fell back to identify), or identified.",
+	}, []string{"outcome", "shard"})
+
+	// ResumeURLFallbacks is a counter of resume URLs abandoned because they couldn't be dialed
+	ResumeURLFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "resume_url_fallbacks",
+		Help:      "Counter of resume URLs abandoned for the main gateway URL after repeated dial failures.",
+	}, []string{"shard"})
+
+	// EgressThrottleSeconds is a counter of time spent waiting for the egress byte limiter
+	EgressThrottleSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "egress_throttle_seconds",
+		Help:      "Counter of seconds outbound packets were delayed by the egress bandwidth limit.",
+	}, []string{"shard"})
+
+	// LifecycleEventsDropped is a counter of lifecycle events dropped because a consumer was behind
+	LifecycleEventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "lifecycle_events_dropped",
+		Help:      "Counter of shard lifecycle events dropped because a consumer's channel was full.",
+	})
+
+	// PacketsDropped is a counter of packets dropped because they couldn't be decoded
+	PacketsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "packets_dropped",
+		Help:      "Counter of received packets dropped because they couldn't be decoded.",
+	}, []string{"reason", "shard"})
+
+	// PausedDispatches is a counter of dispatches received while consumption was paused
+	PausedDispatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "paused_dispatches",
+		Help:      "Counter of dispatches received while consumption was paused, by whether they were buffered or dropped.",
+	}, []string{"outcome", "shard"})
+
+	// UnknownEvents is a counter of dispatches with unrecognized event names
+	UnknownEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "unknown_events",
+		Help:      "Counter of dispatches received with an unrecognized event name.",
+	}, []string{"t", "shard"})
+
+	// SchemaMismatches is a counter of dispatch payload values that don't match their schema
+	SchemaMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "schema_mismatches",
+		Help:      "Counter of dispatch payload values that don't match their event's schema.",
+	}, []string{"t", "shard"})
+
+	// BatchesPublished is a counter of batches published to the broker
+	BatchesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "batches_published",
+		Help:      "Counter of batches published to the broker by the limit that triggered them.",
+	}, []string{"reason"})
+
+	// BatchEvents is a histogram of the number of events in each published batch
+	BatchEvents = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gateway",
+		Name:      "batch_events",
+		Help:      "Number of events in each batch published to the broker.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	// BatchBytes is a histogram of the size of each published batch
+	BatchBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gateway",
+		Name:      "batch_bytes",
+		Help:      "Size of the event data in each batch published to the broker (in bytes).",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 10),
+	})
+
+	// ShardReceivedBytes is a counter of decompressed bytes received by each shard
+	ShardReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "shard_received_bytes",
+		Help:      "Counter of decompressed bytes received.",
+	}, []string{"shard"})
+
+	// ShardHandlerWallSeconds is a counter of the estimated wall time each shard spends handling
+	// packets
+	ShardHandlerWallSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "shard_handler_wall_seconds",
+		Help:      "Estimated wall time spent decoding and handling packets, extrapolated from a sample.",
+	}, []string{"shard"})
+
+	// StartupShards is a gauge of the manager's shards in each startup phase
+	StartupShards = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "startup_shards",
+		Help:      "Number of shards in each startup phase: pending, connecting, identifying, or ready.",
+	}, []string{"phase"})
+
+	// StartupRemaining is a gauge of the estimated time until every shard has been ready
+	StartupRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "startup_remaining_seconds",
+		Help:      "Estimated time until every shard has been ready (in seconds).",
+	})
+
+	// ReadOnlyRefused is a counter of packets read-only shards refused to send
+	ReadOnlyRefused = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "read_only_refused",
+		Help:      "Counter of packets that read-only shards refused to send.",
+	}, []string{"op", "shard"})
+
+	// ChaosFaults is a counter of faults injected into shards
+	ChaosFaults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "chaos_faults",
+		Help:      "Counter of faults injected into shards for testing, by fault.",
+	}, []string{"fault", "shard"})
+
+	// ShardStoreSeconds is a histogram of the latency of shard store calls
+	ShardStoreSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gateway",
+		Name:      "shard_store_seconds",
+		Help:      "Latency of shard store calls (in seconds), by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"operation"})
+
+	// ShardStoreErrors is a counter of failed shard store calls
+	ShardStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "shard_store_errors",
+		Help:      "Counter of failed shard store calls, by operation.",
+	}, []string{"operation"})
+
+	// BusEvents is a counter of dispatches delivered to or dropped by event bus subscribers
+	BusEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "bus_events",
+		Help:      "Counter of dispatches for event bus subscribers, by outcome: delivered or dropped.",
+	}, []string{"subscriber", "outcome", "shard"})
+
+	// BusQueueLength is a gauge of the events waiting to be received by each event bus subscriber
+	BusQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "bus_queue_length",
+		Help:      "Number of events waiting to be received by each event bus subscriber.",
+	}, []string{"subscriber"})
+
+	// SampledOut is a counter of dispatches not passed on due to sampling
+	SampledOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "sampled_out",
+		Help:      "Counter of dispatches not passed on due to sampling.",
+	}, []string{"event", "shard"})
+
+	// SendQueue is a counter of packets handled by shard send queues
+	SendQueue = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "send_queue",
+		Help:      "Counter of packets sent without a session, by outcome: queued, sent, expired, or overflow.",
+	}, []string{"outcome", "shard"})
+
 	// ShardsAlive is a gauge of the number of shards alive
 	ShardsAlive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "gateway",
@@ -45,8 +241,78 @@ var (
 			0.99: 0.001,
 		},
 	}, []string{"id"})
+
+	// CohortShards is a gauge of the number of shards running in each cohort
+	CohortShards = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "cohort_shards",
+		Help:      "Number of shards running in each cohort (canary or baseline).",
+	}, []string{"cohort"})
+
+	// CohortDispatches is a counter of dispatches received by each cohort
+	CohortDispatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "cohort_dispatches",
+		Help:      "Counter of dispatches received by each cohort (canary or baseline).",
+	}, []string{"cohort"})
+
+	// CohortDisconnects is a counter of connection errors in each cohort
+	CohortDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "cohort_disconnects",
+		Help:      "Counter of connections ended by an error in each cohort (canary or baseline).",
+	}, []string{"cohort"})
+
+	// CohortPing is a summary of heartbeat latency in each cohort
+	CohortPing = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: "gateway",
+		Name:      "cohort_ping",
+		Help:      "Latency between heartbeat and acknowledgement in each cohort (in milliseconds).",
+		Objectives: map[float64]float64{
+			0.5:  0.05,
+			0.9:  0.01,
+			0.95: 0.005,
+			0.99: 0.001,
+		},
+	}, []string{"cohort"})
+)
+
+// collectors contains every metric exported by this package
+var collectors = []prometheus.Collector{
+	PacketsReceived, PacketsSent, EgressThrottleSeconds, PacketsDropped, PausedDispatches, UnknownEvents, SchemaMismatches, SessionOutcomes, ResumeURLFallbacks, Disconnects, LifecycleEventsDropped, ShardsAlive, TotalShards, Ping,
+	ReconnectAttempts, ReconnectBackoff, ReconnectStreak, BatchesPublished, BatchEvents, BatchBytes,
+	SendQueue, SampledOut, ShardReceivedBytes, ShardHandlerWallSeconds, BusEvents, BusQueueLength,
+	ShardStoreSeconds, ShardStoreErrors, ChaosFaults, ReadOnlyRefused,
+	StartupShards, StartupRemaining,
+	CohortShards, CohortDispatches, CohortDisconnects, CohortPing,
+}
+
+var (
+	registererMu sync.Mutex
+	registerer   prometheus.Registerer = prometheus.DefaultRegisterer
 )
 
 func init() {
-	prometheus.MustRegister(PacketsReceived, PacketsSent, ShardsAlive, TotalShards, Ping)
+	for _, c := range collectors {
+		registerer.MustRegister(c)
+	}
+}
+
+// SetLabels re-registers every metric with the given constant labels (e.g. cluster, replica, or
+// environment) attached, replacing any labels set previously. Labels apply process-wide.
+func SetLabels(labels prometheus.Labels) error {
+	registererMu.Lock()
+	defer registererMu.Unlock()
+
+	for _, c := range collectors {
+		registerer.Unregister(c)
+	}
+
+	registerer = prometheus.WrapRegistererWith(labels, prometheus.DefaultRegisterer)
+	for _, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
 }
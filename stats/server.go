@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ShutdownTimeout is how long Serve waits for in-flight scrapes when shutting down
+const ShutdownTimeout = 5 * time.Second
+
+// ServerOptions configures the metrics HTTP listener
+type ServerOptions struct {
+	Address string
+	// Path is the path metrics are served at; if empty, they are served at every path
+	Path string
+
+	// TLSCert and TLSKey are the certificate and key files used to serve metrics over HTTPS
+	TLSCert string
+	TLSKey  string
+}
+
+// Serve serves metrics until the context is cancelled, then shuts the listener down gracefully.
+// Returns nil if the listener was shut down.
+func Serve(ctx context.Context, opts ServerOptions) error {
+	var handler http.Handler = promhttp.Handler()
+	if opts.Path != "" {
+		mux := http.NewServeMux()
+		mux.Handle(opts.Path, handler)
+		handler = mux
+	}
+
+	server := &http.Server{
+		Addr:    opts.Address,
+		Handler: handler,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if opts.TLSCert != "" {
+			errs <- server.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}